@@ -0,0 +1,160 @@
+package admin_test
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dzrw/trace"
+	"github.com/dzrw/trace/admin"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	SiteTestQuery  = trace.Site()
+	SiteTestHealth = trace.Site()
+	ProbeTestMiss  = trace.NewProbe("admin_test.miss")
+	ProbeTestApp   = trace.NewProbe("admin_test.app")
+)
+
+func TestServer(t *testing.T) {
+	reg := trace.NewRegistry()
+	reg.Define(SiteTestQuery, "db.query")
+	reg.Define(SiteTestHealth, "db.health")
+	reg.Define(ProbeTestMiss.Site(), "probe.miss")
+
+	h := trace.NewTextHandler(io.Discard, trace.ErrorLevel, false, false, reg)
+	SiteTestQuery.Install(h)
+	defer SiteTestQuery.Uninstall()
+
+	path := filepath.Join(t.TempDir(), "trace.sock")
+	srv, err := admin.Listen(path, reg)
+	require.NoError(t, err)
+	defer srv.Close()
+
+	fi, err := os.Stat(path)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0o600), fi.Mode().Perm())
+
+	c, err := admin.Dial(path)
+	require.NoError(t, err)
+	defer c.Close()
+
+	level, sites, err := c.Sites()
+	require.NoError(t, err)
+	require.Equal(t, "DEBUG-99", level)
+	require.Contains(t, sites, admin.SiteInfo{
+		ID:         SiteTestQuery.ID().String(),
+		Identifier: "db.query",
+		Handler:    "*trace.TextHandler",
+		Enabled:    true,
+	})
+
+	defer func() {
+		trace.SetDefaultLevel(trace.NoiseLevel)
+		trace.EnableSites(reg, "*")
+	}()
+	level, _, err = c.SetLevel("debug", "-db.health")
+	require.NoError(t, err)
+	require.Equal(t, "DEBUG", level)
+	require.True(t, trace.SiteEnabled(SiteTestQuery))
+	require.False(t, trace.SiteEnabled(SiteTestHealth))
+
+	_, _, err = c.SetLevel("loud", "")
+	require.EqualError(t, err, `trace: invalid level "loud"`)
+
+	probes, err := c.Arm("admin_test.miss", map[string]string{"key": "b"}, 1)
+	require.NoError(t, err)
+	require.Contains(t, probes, admin.ProbeInfo{Name: "admin_test.miss", Identifier: "probe.miss", Armed: true})
+	_, err = c.Arm("admin_test.nope", nil, 0)
+	require.Error(t, err)
+
+	tc, err := admin.Dial(path)
+	require.NoError(t, err)
+	defer tc.Close()
+
+	events := make(chan admin.Event, 64)
+	go tc.Tail(admin.Request{Sites: "*", Level: "debug"}, func(ev admin.Event, dropped uint64) error {
+		select {
+		case events <- ev:
+		default:
+		}
+		return nil
+	})
+
+	// Wait for the subscription by retrying until an event arrives.
+	var ev admin.Event
+	for ev.Site == "" {
+		SiteTestQuery.Trace().Debug("querying")
+		select {
+		case ev = <-events:
+		case <-time.After(time.Millisecond):
+		}
+	}
+	require.Equal(t, "db.query", ev.Site)
+	require.Equal(t, "DEBUG", ev.Level)
+	require.Equal(t, "querying", ev.Message)
+
+	ProbeTestMiss.Fire(trace.String("key", "a"))
+	ProbeTestMiss.Fire(trace.String("key", "b"))
+	for ev.Site != "probe.miss" {
+		ev = <-events
+	}
+	require.Equal(t, "admin_test.miss", ev.Message)
+	require.Equal(t, [][2]string{{"key", "b"}}, ev.Attrs)
+
+	probes, err = c.Probes()
+	require.NoError(t, err)
+	require.Contains(t, probes, admin.ProbeInfo{Name: "admin_test.miss", Identifier: "probe.miss", Armed: false})
+
+	_, err = c.Disarm("admin_test.nope")
+	require.EqualError(t, err, `no probe "admin_test.nope"`)
+}
+
+func TestServerArmKeepsHandler(t *testing.T) {
+	reg := trace.NewRegistry()
+	reg.Define(ProbeTestApp.Site(), "probe.app")
+
+	buf := bytes.Buffer{}
+	h := trace.NewTextHandler(&buf, trace.DebugLevel, false, false, reg)
+	require.NoError(t, trace.ArmProbe("admin_test.app", trace.ProbeOptions{Handler: h}))
+	defer trace.DisarmProbe("admin_test.app")
+
+	path := filepath.Join(t.TempDir(), "trace.sock")
+	srv, err := admin.Listen(path, reg)
+	require.NoError(t, err)
+	defer srv.Close()
+
+	c, err := admin.Dial(path)
+	require.NoError(t, err)
+	defer c.Close()
+
+	// Rearming the probe from a client keeps the application's handler.
+	_, err = c.Arm("admin_test.app", map[string]string{"key": "b"}, 0)
+	require.NoError(t, err)
+	ProbeTestApp.Fire(trace.String("key", "a"))
+	ProbeTestApp.Fire(trace.String("key", "b"))
+	require.Equal(t, "site=probe.app trace=0 key=b event=admin_test.app\n", buf.String())
+}
+
+func TestFrames(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "trace.sock")
+	srv, err := admin.Listen(path, nil)
+	require.NoError(t, err)
+
+	c, err := admin.Dial(path)
+	require.NoError(t, err)
+	defer c.Close()
+
+	_, err = c.Do(admin.Request{Op: "reboot"})
+	require.EqualError(t, err, `unknown op "reboot"`)
+	require.NoError(t, srv.Close())
+
+	// The socket is removed on Close, so the path can be listened on again.
+	srv, err = admin.Listen(path, nil)
+	require.NoError(t, err)
+	require.NoError(t, srv.Close())
+}
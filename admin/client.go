@@ -0,0 +1,104 @@
+package admin
+
+import (
+	"errors"
+	"net"
+)
+
+// Client is a connection to a Server. Its methods must not be called
+// concurrently.
+type Client struct {
+	conn net.Conn
+}
+
+// Dial connects to the Server listening on the unix socket at path.
+func Dial(path string) (*Client, error) {
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	return NewClient(conn), nil
+}
+
+// NewClient creates a Client that speaks the admin protocol over conn.
+func NewClient(conn net.Conn) *Client {
+	return &Client{conn: conn}
+}
+
+// Close closes the connection.
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// Do sends req and returns the Response. A Response that carries an error is
+// returned as an error.
+func (c *Client) Do(req Request) (Response, error) {
+	if err := WriteFrame(c.conn, req); err != nil {
+		return Response{}, err
+	}
+	return c.read()
+}
+
+func (c *Client) read() (Response, error) {
+	var resp Response
+	if err := ReadFrame(c.conn, &resp); err != nil {
+		return Response{}, err
+	}
+	if resp.Error != "" {
+		return resp, errors.New(resp.Error)
+	}
+	return resp, nil
+}
+
+// Sites returns the default level and the sites of the process.
+func (c *Client) Sites() (string, []SiteInfo, error) {
+	resp, err := c.Do(Request{Op: OpSites})
+	return resp.Level, resp.Sites, err
+}
+
+// SetLevel sets the default level, if level is not empty, and the enabled
+// sites, if sites is not empty. It returns the new default level and sites.
+func (c *Client) SetLevel(level, sites string) (string, []SiteInfo, error) {
+	resp, err := c.Do(Request{Op: OpLevel, Level: level, Sites: sites})
+	return resp.Level, resp.Sites, err
+}
+
+// Probes returns the probes of the process.
+func (c *Client) Probes() ([]ProbeInfo, error) {
+	resp, err := c.Do(Request{Op: OpProbes})
+	return resp.Probes, err
+}
+
+// Arm arms the probes named name. Their hits are streamed to tails.
+func (c *Client) Arm(name string, match map[string]string, limit int) ([]ProbeInfo, error) {
+	resp, err := c.Do(Request{Op: OpArm, Probe: name, Match: match, Limit: limit})
+	return resp.Probes, err
+}
+
+// Disarm disarms the probes named name.
+func (c *Client) Disarm(name string) ([]ProbeInfo, error) {
+	resp, err := c.Do(Request{Op: OpDisarm, Probe: name})
+	return resp.Probes, err
+}
+
+// Tail streams the events selected by req, whose Op is ignored, to f along
+// with the number of events dropped so far. It returns when f returns an
+// error, which Tail returns, or when the connection fails. The connection
+// cannot be used after Tail returns.
+func (c *Client) Tail(req Request, f func(ev Event, dropped uint64) error) error {
+	req.Op = OpTail
+	if _, err := c.Do(req); err != nil {
+		return err
+	}
+	for {
+		resp, err := c.read()
+		if err != nil {
+			return err
+		}
+		if resp.Event != nil {
+			if err := f(*resp.Event, resp.Dropped); err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,22 @@
+package admin
+
+import (
+	"net"
+	"os"
+)
+
+// listenUnix creates a unix socket at path that only the current user may
+// connect to. The socket is restricted before it is served, rather than by
+// narrowing the umask, which would apply to the files created by every
+// goroutine of the process meanwhile.
+func listenUnix(path string) (net.Listener, error) {
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0o600); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	return ln, nil
+}
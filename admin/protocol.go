@@ -0,0 +1,139 @@
+/*
+Package admin exposes a process's tracepoints on a unix socket, so that an
+operator can inspect and reconfigure them at run time with cmd/tracectl.
+
+	srv, err := admin.Listen("/run/myapp/trace.sock", reg)
+	if err != nil {
+		return err
+	}
+	defer srv.Close()
+
+The protocol is a sequence of frames, each a 4-byte big-endian length
+followed by that many bytes of JSON. A client sends a Request and the server
+replies with a Response, except for a tail, to which the server replies with
+a Response for each event until the client disconnects.
+*/
+package admin
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+// MaxFrameSize is the size of the largest frame that is read.
+const MaxFrameSize = 1 << 20
+
+// Ops are the operations of a Request.
+const (
+	OpSites  = "sites"  // OpSites lists the sites and their handlers.
+	OpLevel  = "level"  // OpLevel sets the default level and enabled sites.
+	OpProbes = "probes" // OpProbes lists the probes.
+	OpArm    = "arm"    // OpArm arms a probe.
+	OpDisarm = "disarm" // OpDisarm disarms a probe.
+	OpTail   = "tail"   // OpTail streams events.
+)
+
+// Request is sent by a client.
+type Request struct {
+	Op string `json:"op"`
+
+	// Level is a level, as accepted by trace.ParseLevel. For OpLevel, it is
+	// the new default level, if not empty; for OpTail, it is the least
+	// severe level to stream.
+	Level string `json:"level,omitempty"`
+
+	// Sites is a list of patterns, as accepted by trace.EnableSites, for
+	// OpLevel, and a pattern, as accepted by trace.MatchIdentifier, for
+	// OpTail.
+	Sites string `json:"sites,omitempty"`
+
+	// Probe is the name of the probe to arm or disarm.
+	Probe string `json:"probe,omitempty"`
+
+	// Match is the condition of an armed probe or a tail, as keys and
+	// formatted values of attrs.
+	Match map[string]string `json:"match,omitempty"`
+
+	// Limit is the number of hits after which an armed probe disarms itself.
+	Limit int `json:"limit,omitempty"`
+
+	// Sample is the fraction of events to stream in a tail, or zero for all.
+	Sample float64 `json:"sample,omitempty"`
+}
+
+// Response is sent by the server.
+type Response struct {
+	Error  string      `json:"error,omitempty"`
+	Level  string      `json:"level,omitempty"` // the default level
+	Sites  []SiteInfo  `json:"sites,omitempty"`
+	Probes []ProbeInfo `json:"probes,omitempty"`
+	Event  *Event      `json:"event,omitempty"`
+
+	// Dropped is the number of events that a tail has dropped so far
+	// because the client could not keep up.
+	Dropped uint64 `json:"dropped,omitempty"`
+}
+
+// SiteInfo describes a site.
+type SiteInfo struct {
+	ID         string `json:"id"`
+	Identifier string `json:"identifier,omitempty"`
+	Handler    string `json:"handler,omitempty"` // the type of the installed Handler
+	Enabled    bool   `json:"enabled"`
+	Errors     uint64 `json:"errors,omitempty"` // the errors reported for the Handler
+}
+
+// ProbeInfo describes a probe.
+type ProbeInfo struct {
+	Name       string `json:"name"`
+	Identifier string `json:"identifier,omitempty"`
+	Armed      bool   `json:"armed"`
+}
+
+// Event is an event streamed by a tail.
+type Event struct {
+	Time     time.Time   `json:"time"`
+	Level    string      `json:"level"`
+	Site     string      `json:"site"`
+	Trace    uint64      `json:"trace"`
+	Message  string      `json:"message,omitempty"`
+	Attrs    [][2]string `json:"attrs,omitempty"` // keys and formatted values
+	Function string      `json:"function,omitempty"`
+	File     string      `json:"file,omitempty"`
+	Line     int         `json:"line,omitempty"`
+}
+
+// WriteFrame writes v to w as a frame.
+func WriteFrame(w io.Writer, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, 4, 4+len(data))
+	binary.BigEndian.PutUint32(buf, uint32(len(data)))
+	_, err = w.Write(append(buf, data...))
+	return err
+}
+
+// ReadFrame reads a frame from r into v.
+func ReadFrame(r io.Reader, v interface{}) error {
+	var hdr [4]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return err
+	}
+	n := binary.BigEndian.Uint32(hdr[:])
+	if n > MaxFrameSize {
+		return fmt.Errorf("admin: frame of %d bytes exceeds %d", n, MaxFrameSize)
+	}
+	data := make([]byte, n)
+	if _, err := io.ReadFull(r, data); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
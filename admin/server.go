@@ -0,0 +1,307 @@
+package admin
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/dzrw/trace"
+)
+
+// Server serves the admin protocol.
+type Server struct {
+	reg trace.Registry
+	ln  net.Listener
+
+	mu     sync.Mutex
+	conns  map[net.Conn]struct{}
+	closed bool
+	wg     sync.WaitGroup
+}
+
+// Listen creates a unix socket at path, which only the current user may
+// connect to, and serves the admin protocol on it. Sites are identified by
+// reg. A stale socket left at path by a process that exited is replaced.
+func Listen(path string, reg trace.Registry) (*Server, error) {
+	ln, err := listenUnix(path)
+	if err != nil {
+		if c, derr := net.Dial("unix", path); derr == nil {
+			c.Close()
+			return nil, err
+		}
+		if rerr := os.Remove(path); rerr != nil {
+			return nil, err
+		}
+		if ln, err = listenUnix(path); err != nil {
+			return nil, err
+		}
+	}
+	return NewServer(ln, reg), nil
+}
+
+// NewServer serves the admin protocol on the connections accepted by ln.
+// Sites are identified by reg.
+func NewServer(ln net.Listener, reg trace.Registry) *Server {
+	if reg == nil {
+		reg = trace.NewRegistry()
+	}
+	s := &Server{
+		reg:   reg,
+		ln:    ln,
+		conns: make(map[net.Conn]struct{}),
+	}
+	s.wg.Add(1)
+	go s.accept()
+	return s
+}
+
+// Addr returns the address of the listener.
+func (s *Server) Addr() net.Addr {
+	return s.ln.Addr()
+}
+
+// Close stops the listener, disconnects every client and waits for their
+// requests to finish.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	for c := range s.conns {
+		c.Close()
+	}
+	s.mu.Unlock()
+
+	err := s.ln.Close()
+	s.wg.Wait()
+	return err
+}
+
+func (s *Server) accept() {
+	defer s.wg.Done()
+	for {
+		c, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			c.Close()
+			return
+		}
+		s.conns[c] = struct{}{}
+		s.wg.Add(1)
+		s.mu.Unlock()
+
+		go s.serve(c)
+	}
+}
+
+func (s *Server) serve(c net.Conn) {
+	defer s.wg.Done()
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, c)
+		s.mu.Unlock()
+		c.Close()
+	}()
+
+	for {
+		var req Request
+		if err := ReadFrame(c, &req); err != nil {
+			return
+		}
+		if req.Op == OpTail {
+			s.tail(c, req)
+			return
+		}
+		if err := WriteFrame(c, s.handle(req)); err != nil {
+			return
+		}
+	}
+}
+
+func (s *Server) handle(req Request) Response {
+	switch req.Op {
+	case OpSites:
+		return Response{Level: trace.DefaultLevel().String(), Sites: s.sites()}
+	case OpLevel:
+		if req.Level != "" {
+			l, err := trace.ParseLevel(req.Level)
+			if err != nil {
+				return Response{Error: err.Error()}
+			}
+			trace.SetDefaultLevel(l)
+		}
+		if req.Sites != "" {
+			trace.EnableSites(s.reg, req.Sites)
+		}
+		return Response{Level: trace.DefaultLevel().String(), Sites: s.sites()}
+	case OpProbes:
+		return Response{Probes: s.probes()}
+	case OpArm:
+		err := trace.ArmProbe(req.Probe, trace.ProbeOptions{
+			Handler: s.probeHandler(req.Probe),
+			Match:   attrsOf(req.Match),
+			Limit:   req.Limit,
+		})
+		if err != nil {
+			return Response{Error: err.Error()}
+		}
+		return Response{Probes: s.probes()}
+	case OpDisarm:
+		if !trace.DisarmProbe(req.Probe) {
+			return Response{Error: fmt.Sprintf("no probe %q", req.Probe)}
+		}
+		return Response{Probes: s.probes()}
+	}
+	return Response{Error: fmt.Sprintf("unknown op %q", req.Op)}
+}
+
+// probeHandler returns the Handler to arm the probes named name with: the
+// Handler the application armed them with, if any, so that a client does not
+// take the probes' events away from it.
+func (s *Server) probeHandler(name string) trace.Handler {
+	for _, p := range trace.Probes() {
+		if p.String() != name {
+			continue
+		}
+		if h, ok := p.Site().Handler(); ok {
+			return h
+		}
+	}
+	return nopHandler{}
+}
+
+func (s *Server) sites() []SiteInfo {
+	var arr []SiteInfo
+	for _, tp := range trace.Sites() {
+		info := SiteInfo{ID: tp.ID().String(), Enabled: trace.SiteEnabled(tp)}
+		info.Identifier, _ = s.reg.IdentifierFor(tp)
+		if h, ok := tp.Handler(); ok {
+			info.Handler = handlerName(h)
+			info.Errors = trace.SiteErrors(tp)
+		}
+		arr = append(arr, info)
+	}
+	return arr
+}
+
+func (s *Server) probes() []ProbeInfo {
+	var arr []ProbeInfo
+	for _, p := range trace.Probes() {
+		info := ProbeInfo{Name: p.String(), Armed: p.Armed()}
+		info.Identifier, _ = s.reg.IdentifierFor(p.Site())
+		arr = append(arr, info)
+	}
+	return arr
+}
+
+// handlerName returns the type of h, and of the root of its graph if h is a
+// Switchboard.
+func handlerName(h trace.Handler) string {
+	if sb, ok := h.(*trace.Switchboard); ok {
+		if cur := sb.Current(); cur != nil {
+			return fmt.Sprintf("%T(%T)", h, cur)
+		}
+	}
+	return fmt.Sprintf("%T", h)
+}
+
+// tail streams the events selected by req until the client disconnects or
+// the server closes.
+func (s *Server) tail(c net.Conn, req Request) {
+	f := trace.Filter{
+		Sites:    req.Sites,
+		Registry: s.reg,
+		Match:    attrsOf(req.Match),
+	}
+	if req.Level != "" {
+		l, err := trace.ParseLevel(req.Level)
+		if err != nil {
+			WriteFrame(c, Response{Error: err.Error()})
+			return
+		}
+		f.Level = l
+	}
+	if req.Sample > 0 && req.Sample < 1 {
+		f.Where = func(trace.Record) bool { return rand.Float64() < req.Sample }
+	}
+
+	ch, cancel := trace.Subscribe(f)
+	defer cancel()
+
+	// The client sends nothing more; a read returns when it disconnects.
+	gone := make(chan struct{})
+	go func() {
+		defer close(gone)
+		io.Copy(io.Discard, c)
+	}()
+
+	if err := WriteFrame(c, Response{Level: trace.DefaultLevel().String()}); err != nil {
+		return
+	}
+	for {
+		select {
+		case r := <-ch:
+			resp := Response{Event: s.event(r), Dropped: trace.Dropped(ch)}
+			c.SetWriteDeadline(time.Now().Add(10 * time.Second))
+			if err := WriteFrame(c, resp); err != nil {
+				return
+			}
+		case <-gone:
+			return
+		}
+	}
+}
+
+func (s *Server) event(r trace.Record) *Event {
+	ev := &Event{
+		Time:    r.Time,
+		Level:   r.Level.String(),
+		Trace:   r.Trace.ID(),
+		Message: r.Message,
+	}
+	ev.Site, _ = s.reg.IdentifierFor(r.Site)
+	r.Attrs(func(a trace.Attr) bool {
+		k, v := a.Format()
+		ev.Attrs = append(ev.Attrs, [2]string{k, v})
+		return true
+	})
+	ev.Function, ev.File, ev.Line = r.Source()
+	return ev
+}
+
+func attrsOf(m map[string]string) []trace.Attr {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var arr []trace.Attr
+	for _, k := range keys {
+		arr = append(arr, trace.String(k, m[k]))
+	}
+	return arr
+}
+
+// nopHandler is installed into the probes armed by a client that have no
+// Handler. It accepts every event and discards it, so that the events reach
+// tails.
+type nopHandler struct{}
+
+func (nopHandler) Flags() trace.HandlerFlags                           { return 0 }
+func (nopHandler) Enabled(trace.Level) bool                            { return true }
+func (nopHandler) Count(trace.Tracepoint, int64) error                 { return nil }
+func (nopHandler) Gauge(trace.Tracepoint, int64) error                 { return nil }
+func (nopHandler) Duration(trace.Tracepoint, time.Duration) error      { return nil }
+func (nopHandler) Histogram(trace.Tracepoint, int64) error             { return nil }
+func (nopHandler) Log(trace.Trace, trace.Level, ...[]trace.Attr) error { return nil }
+func (nopHandler) TraceCreated(trace.Trace, []trace.Attr)              {}
+func (nopHandler) TraceFinished(trace.Trace, []trace.Attr)             {}
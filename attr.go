@@ -157,6 +157,12 @@ func (a Attr) Format() (key, value string) {
 		value = a.Time().Format(RFC3339Milli)
 	case Uint64Kind:
 		value = fmt.Sprint(a.Uint64())
+	case LinkKind:
+		value = a.RemoteContext().String()
+	case StackKind:
+		value = formatStackTrace(a.Frames())
+	case SourceKind:
+		value = formatSource(a.Source())
 	case AnyKind, ErrorKind, NoErrorKind:
 		fallthrough
 	default:
@@ -198,6 +204,12 @@ func (a Attr) Kind() Kind {
 	return a.kind
 }
 
+// RemoteContext returns the Attr's value as a RemoteContext. It panics if the
+// value is not a RemoteContext.
+func (a Attr) RemoteContext() RemoteContext {
+	return *(a.val.(*RemoteContext))
+}
+
 // String returns Attr's value as a string, formatted like fmt.Sprint.
 // Unlike the methods Int64, Float64, and so on, which panic if the Attr is of
 // the wrong kind, String never panics.
@@ -0,0 +1,225 @@
+/*
+Command tracectl inspects and reconfigures the tracepoints of a process that
+serves the admin protocol of package admin on a unix socket.
+
+Usage:
+
+	tracectl [-socket path] command [flags] [args]
+
+The commands are:
+
+	sites                          list the sites and their handlers
+	level [-sites patterns] [level]
+	                               set the default level and enabled sites
+	probes                         list the probes
+	arm [-match k=v]... [-limit n] name
+	                               arm a probe; its hits are streamed to tails
+	disarm name                    disarm a probe
+	tail [-site pattern] [-level level] [-match k=v]... [-sample rate]
+	                               stream events until interrupted
+
+The socket defaults to $TRACE_ADMIN_SOCKET. For example,
+
+	tracectl tail --site 'db.*' --level debug
+*/
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/dzrw/trace"
+	"github.com/dzrw/trace/admin"
+)
+
+func main() {
+	if err := run(os.Args[1:], os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, "tracectl:", err)
+		os.Exit(1)
+	}
+}
+
+var errUsage = errors.New("usage: tracectl [-socket path] sites|level|probes|arm|disarm|tail [flags] [args]")
+
+func run(args []string, w io.Writer) error {
+	fs := flag.NewFlagSet("tracectl", flag.ContinueOnError)
+	socket := fs.String("socket", os.Getenv("TRACE_ADMIN_SOCKET"), "path of the admin socket")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return errUsage
+	}
+	if *socket == "" {
+		return errors.New("no socket: set -socket or TRACE_ADMIN_SOCKET")
+	}
+
+	c, err := admin.Dial(*socket)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	cmd, args := fs.Arg(0), fs.Args()[1:]
+	switch cmd {
+	case "sites":
+		level, sites, err := c.Sites()
+		if err != nil {
+			return err
+		}
+		printSites(w, level, sites)
+	case "level":
+		fs := flag.NewFlagSet("level", flag.ContinueOnError)
+		sites := fs.String("sites", "", "comma-separated patterns of the sites to enable, or to disable if prefixed with '-'")
+		if err := fs.Parse(args); err != nil {
+			return err
+		}
+		level, infos, err := c.SetLevel(fs.Arg(0), *sites)
+		if err != nil {
+			return err
+		}
+		printSites(w, level, infos)
+	case "probes":
+		probes, err := c.Probes()
+		if err != nil {
+			return err
+		}
+		printProbes(w, probes)
+	case "arm":
+		fs := flag.NewFlagSet("arm", flag.ContinueOnError)
+		match := matchFlag{}
+		fs.Var(match, "match", "condition on an attr, as key=value; may be repeated")
+		limit := fs.Int("limit", 0, "number of hits after which the probe disarms itself")
+		if err := fs.Parse(args); err != nil {
+			return err
+		}
+		if fs.NArg() != 1 {
+			return errors.New("usage: tracectl arm [-match k=v]... [-limit n] name")
+		}
+		probes, err := c.Arm(fs.Arg(0), match, *limit)
+		if err != nil {
+			return err
+		}
+		printProbes(w, probes)
+	case "disarm":
+		if len(args) != 1 {
+			return errors.New("usage: tracectl disarm name")
+		}
+		probes, err := c.Disarm(args[0])
+		if err != nil {
+			return err
+		}
+		printProbes(w, probes)
+	case "tail":
+		fs := flag.NewFlagSet("tail", flag.ContinueOnError)
+		site := fs.String("site", "", "pattern of the sites to tail")
+		level := fs.String("level", "", "least severe level to tail")
+		match := matchFlag{}
+		fs.Var(match, "match", "condition on an attr, as key=value; may be repeated")
+		sample := fs.Float64("sample", 0, "fraction of the events to tail")
+		if err := fs.Parse(args); err != nil {
+			return err
+		}
+		req := admin.Request{Sites: *site, Level: *level, Match: match, Sample: *sample}
+		var reported uint64
+		return c.Tail(req, func(ev admin.Event, dropped uint64) error {
+			if dropped > reported {
+				fmt.Fprintf(w, "tracectl: %d events dropped\n", dropped-reported)
+				reported = dropped
+			}
+			_, err := io.WriteString(w, formatEvent(ev))
+			return err
+		})
+	default:
+		return errUsage
+	}
+	return nil
+}
+
+func printSites(w io.Writer, level string, sites []admin.SiteInfo) {
+	fmt.Fprintf(w, "default level: %s\n", level)
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "IDENTIFIER\tID\tENABLED\tHANDLER\tERRORS")
+	for _, s := range sites {
+		id := s.Identifier
+		if id == "" {
+			id = "-"
+		}
+		handler := s.Handler
+		if handler == "" {
+			handler = "-"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%t\t%s\t%d\n", id, s.ID, s.Enabled, handler, s.Errors)
+	}
+	tw.Flush()
+}
+
+func printProbes(w io.Writer, probes []admin.ProbeInfo) {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tIDENTIFIER\tARMED")
+	for _, p := range probes {
+		id := p.Identifier
+		if id == "" {
+			id = "-"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%t\n", p.Name, id, p.Armed)
+	}
+	tw.Flush()
+}
+
+// formatEvent formats ev like a TextHandler, preceded by its time and level.
+func formatEvent(ev admin.Event) string {
+	sb := strings.Builder{}
+	sb.WriteString(ev.Time.Format(trace.RFC3339Milli))
+	sb.WriteByte(' ')
+	sb.WriteString(ev.Level)
+	writeAttr(&sb, "site", ev.Site)
+	writeAttr(&sb, "trace", strconv.FormatUint(ev.Trace, 10))
+	for _, kv := range ev.Attrs {
+		writeAttr(&sb, kv[0], kv[1])
+	}
+	if ev.Message != "" {
+		writeAttr(&sb, "event", ev.Message)
+	}
+	if ev.File != "" {
+		writeAttr(&sb, "source", ev.Function+"("+ev.File+":"+strconv.Itoa(ev.Line)+")")
+	}
+	sb.WriteByte('\n')
+	return sb.String()
+}
+
+func writeAttr(sb *strings.Builder, key, value string) {
+	sb.WriteByte(' ')
+	sb.WriteString(key)
+	sb.WriteByte('=')
+	if value == "" || strings.ContainsAny(value, " \t\n\"=") {
+		value = strconv.Quote(value)
+	}
+	sb.WriteString(value)
+}
+
+// matchFlag collects repeated key=value flags.
+type matchFlag map[string]string
+
+func (m matchFlag) String() string {
+	arr := make([]string, 0, len(m))
+	for k, v := range m {
+		arr = append(arr, k+"="+v)
+	}
+	return strings.Join(arr, ",")
+}
+
+func (m matchFlag) Set(s string) error {
+	k, v, ok := strings.Cut(s, "=")
+	if !ok || k == "" {
+		return fmt.Errorf("invalid match %q: want key=value", s)
+	}
+	m[k] = v
+	return nil
+}
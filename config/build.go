@@ -0,0 +1,545 @@
+package config
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/dzrw/trace"
+	"github.com/dzrw/trace/graphite"
+	"github.com/dzrw/trace/influxdb"
+	tracelogrus "github.com/dzrw/trace/logrus"
+	"github.com/dzrw/trace/prometheus"
+	"github.com/dzrw/trace/statsd"
+	log "github.com/sirupsen/logrus"
+)
+
+var _ = trace.Handler(&Graph{})
+var _ = trace.RecordHandler(&Graph{})
+var _ = trace.LinkHandler(&Graph{})
+var _ = trace.Flusher(&Graph{})
+var _ = trace.Closer(&Graph{})
+var _ = trace.HandlerGroup(&Graph{})
+
+// Redacted replaces the values of redacted attrs.
+const Redacted = "[REDACTED]"
+
+/*
+Graph is the Handler built from a Config. It applies the level, sampling and
+redaction of each site before passing its events to the sinks of the site's
+route. Sites are identified through the registry given to Build; the settings
+of a site are resolved when it is first seen.
+
+The flags of a Graph are those of all its sinks, so that call sites, goroutine
+IDs and stack traces are captured if any sink wants them; they are removed
+from the events and trace notifications of the sinks that do not.
+
+Handler.Enabled has no site, so Enabled accepts the levels of the most
+verbose site, and events are filtered by site when they are handled.
+*/
+type Graph struct {
+	reg    trace.Registry
+	c      *Config
+	sinks  []*sink
+	flags  trace.HandlerFlags
+	max    trace.Level
+	redact map[string]bool
+
+	sites sync.Map // map[trace.Tracepoint]*site
+
+	closers []io.Closer
+	once    sync.Once
+}
+
+// sink is a built Sink.
+type sink struct {
+	name   string
+	h      trace.Handler
+	rh     trace.RecordHandler // h, adapted once
+	strip  trace.HandlerFlags  // the flags of the Graph that h does not set
+	level  trace.Level
+	events bool // the sink writes trace notifications as events at DebugLevel
+}
+
+// site holds the settings resolved for a Tracepoint.
+type site struct {
+	level trace.Level
+	rate  float64
+	seed  uint64
+	sinks []*sink
+}
+
+// Build creates the handlers described by c and the Graph that routes to
+// them. Sites are identified by reg. If a handler cannot be created, those
+// already created are closed and the error is returned.
+func (c *Config) Build(reg trace.Registry) (*Graph, error) {
+	if reg == nil {
+		reg = trace.NewRegistry()
+	}
+
+	g := &Graph{
+		reg:    reg,
+		c:      c,
+		max:    c.level,
+		redact: make(map[string]bool, len(c.Redact)),
+	}
+	for _, s := range c.Sinks {
+		sk, err := g.build(s)
+		if err != nil {
+			g.Close()
+			return nil, &Error{File: c.file, Line: s.node.Line, Column: s.node.Column, Msg: fmt.Sprintf("sink %q: %v", s.Name, err)}
+		}
+		g.sinks = append(g.sinks, sk)
+		g.flags |= sk.h.Flags() & (trace.FlagSourceInfo | trace.FlagGoroutineID | trace.FlagStackTrace)
+	}
+	for _, sk := range g.sinks {
+		sk.strip = g.flags &^ sk.h.Flags()
+	}
+	for _, r := range c.Levels {
+		if r.level > g.max {
+			g.max = r.level
+		}
+	}
+	for _, key := range c.Redact {
+		g.redact[key] = true
+	}
+	return g, nil
+}
+
+func (g *Graph) build(s Sink) (*sink, error) {
+	sk := &sink{name: s.Name, level: s.level}
+
+	var flags trace.HandlerFlags
+	if s.Source {
+		flags |= trace.FlagSourceInfo
+	}
+	if s.Goroutine {
+		flags |= trace.FlagGoroutineID
+	}
+	if s.Stack {
+		flags |= trace.FlagStackTrace
+	}
+
+	switch s.Type {
+	case "text", "json", "logrus":
+		w, err := g.open(s.Output)
+		if err != nil {
+			return nil, err
+		}
+		sk.events = true
+		switch s.Type {
+		case "text":
+			sk.h = trace.NewTextHandlerWithFlags(w, s.level, flags, g.reg)
+		case "json":
+			sk.h = trace.NewJSONHandlerWithFlags(w, s.level, flags, g.reg)
+		default:
+			logger := log.New()
+			logger.SetOutput(w)
+			logger.SetLevel(log.TraceLevel)
+			sk.h = tracelogrus.New(logger, s.Source, s.Goroutine, g.reg)
+		}
+	case "statsd":
+		network := s.Network
+		if network == "" {
+			network = "udp"
+		}
+		h, err := statsd.New(network, s.Address, s.MTU, s.interval, g.reg)
+		if err != nil {
+			return nil, err
+		}
+		sk.h = h
+	case "graphite":
+		sk.h = graphite.New(s.Address, s.Prefix, s.Batch, g.reg)
+	case "influxdb":
+		sk.h = influxdb.New(s.URL, s.Batch, g.reg)
+	case "prometheus":
+		h := prometheus.New(g.reg, s.Buckets)
+		if s.Listen != "" {
+			ls, err := listen(s.Listen, h)
+			if err != nil {
+				return nil, err
+			}
+			g.closers = append(g.closers, ls)
+		}
+		sk.h = h
+	default:
+		return nil, fmt.Errorf("unknown sink type %q", s.Type)
+	}
+
+	if s.aggregate > 0 {
+		sk.h = trace.NewAggregator(sk.h, s.aggregate)
+	}
+	sk.rh = trace.AdaptHandler(sk.h)
+	return sk, nil
+}
+
+// open returns the writer for an output, which is stderr if empty.
+func (g *Graph) open(output string) (io.Writer, error) {
+	switch output {
+	case "", "stderr":
+		return os.Stderr, nil
+	case "stdout":
+		return os.Stdout, nil
+	}
+	f, err := os.OpenFile(output, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	g.closers = append(g.closers, f)
+	return f, nil
+}
+
+// site returns the settings of tp.
+func (g *Graph) site(tp trace.Tracepoint) *site {
+	if v, ok := g.sites.Load(tp); ok {
+		return v.(*site)
+	}
+
+	id, _ := g.reg.IdentifierFor(tp)
+	s := &site{level: g.c.level, rate: 1}
+	for _, r := range g.c.Levels {
+		if trace.MatchIdentifier(r.Sites, id) {
+			s.level = r.level
+		}
+	}
+	for _, r := range g.c.Sampling {
+		if trace.MatchIdentifier(r.Sites, id) {
+			s.rate = r.Rate
+		}
+	}
+	if tp != nil {
+		tpid := tp.ID()
+		s.seed = binary.BigEndian.Uint64(tpid[len(tpid)-8:])
+	}
+
+	if len(g.c.Routes) == 0 {
+		s.sinks = g.sinks
+	} else {
+		for _, r := range g.c.Routes {
+			if trace.MatchIdentifier(r.Sites, id) {
+				for _, name := range r.Sinks {
+					for _, sk := range g.sinks {
+						if sk.name == name {
+							s.sinks = append(s.sinks, sk)
+						}
+					}
+				}
+				break
+			}
+		}
+	}
+
+	v, _ := g.sites.LoadOrStore(tp, s)
+	return v.(*site)
+}
+
+// sampled reports whether the trace tr of the site is kept.
+func (s *site) sampled(tr trace.Trace) bool {
+	switch {
+	case s.rate >= 1:
+		return true
+	case s.rate <= 0:
+		return false
+	}
+	return float64(mix(s.seed^tr.ID())>>11)/(1<<53) < s.rate
+}
+
+// mix is the finalizer of SplitMix64, which spreads sequential trace IDs
+// uniformly over the 64-bit range.
+func mix(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+func (g *Graph) Flags() trace.HandlerFlags {
+	return g.flags
+}
+
+func (g *Graph) Enabled(l trace.Level) bool {
+	return l <= g.max
+}
+
+func (g *Graph) Count(tp trace.Tracepoint, delta int64) error {
+	for _, sk := range g.site(tp).sinks {
+		trace.ReportError(sk.h, "Count", sk.h.Count(tp, delta))
+	}
+	return nil
+}
+
+func (g *Graph) Gauge(tp trace.Tracepoint, value int64) error {
+	for _, sk := range g.site(tp).sinks {
+		trace.ReportError(sk.h, "Gauge", sk.h.Gauge(tp, value))
+	}
+	return nil
+}
+
+func (g *Graph) Duration(tp trace.Tracepoint, d time.Duration) error {
+	for _, sk := range g.site(tp).sinks {
+		trace.ReportError(sk.h, "Duration", sk.h.Duration(tp, d))
+	}
+	return nil
+}
+
+func (g *Graph) Histogram(tp trace.Tracepoint, sample int64) error {
+	for _, sk := range g.site(tp).sinks {
+		trace.ReportError(sk.h, "Histogram", sk.h.Histogram(tp, sample))
+	}
+	return nil
+}
+
+func (g *Graph) Log(tr trace.Trace, l trace.Level, attrs ...[]trace.Attr) error {
+	r := trace.NewRecord(time.Now(), l, "", 0)
+	r.Site, r.Trace = tr.Site(), tr
+	for _, arr := range attrs {
+		r.AddAttrs(arr...)
+	}
+	return g.Handle(r)
+}
+
+// Handle passes r to the sinks of its site if the site's level accepts it
+// and its trace is sampled. Errors are reported against the sink that failed.
+func (g *Graph) Handle(r trace.Record) error {
+	s := g.site(r.Site)
+	if r.Level > s.level {
+		return nil
+	}
+	if r.Level > trace.AssertionViolatedLevel && !s.sampled(r.Trace) {
+		return nil
+	}
+
+	r = g.redactRecord(r)
+	for _, sk := range s.sinks {
+		if r.Level <= sk.level && sk.h.Enabled(r.Level) {
+			trace.ReportError(sk.h, "Log", sk.rh.Handle(sk.stripRecord(r)))
+		}
+	}
+	return nil
+}
+
+func (g *Graph) TraceCreated(tr trace.Trace, attrs []trace.Attr) {
+	if s := g.site(tr.Site()); s.sampled(tr) {
+		tr, attrs = g.redactTrace(tr), g.redactAttrs(attrs)
+		for _, sk := range s.sinks {
+			if s.notifies(sk) {
+				sk.h.TraceCreated(tr, sk.stripAttrs(attrs))
+			}
+		}
+	}
+}
+
+func (g *Graph) TraceFinished(tr trace.Trace, attrs []trace.Attr) {
+	if s := g.site(tr.Site()); s.sampled(tr) {
+		tr, attrs = g.redactTrace(tr), g.redactAttrs(attrs)
+		for _, sk := range s.sinks {
+			if s.notifies(sk) {
+				sk.h.TraceFinished(tr, attrs)
+			}
+		}
+	}
+}
+
+func (g *Graph) TraceLinked(tr trace.Trace, l trace.Link) {
+	if s := g.site(tr.Site()); s.sampled(tr) {
+		tr, l.Attrs = g.redactTrace(tr), g.redactAttrs(l.Attrs)
+		for _, sk := range s.sinks {
+			if lh, ok := sk.h.(trace.LinkHandler); ok && s.notifies(sk) {
+				lh.TraceLinked(tr, l)
+			}
+		}
+	}
+}
+
+// stripRecord returns r without the call site, goroutine ID and stack trace
+// that the Graph captured for other sinks. The stack trace of a panic is
+// part of the event, so it is kept.
+func (sk *sink) stripRecord(r trace.Record) trace.Record {
+	if sk.strip == 0 {
+		return r
+	}
+
+	pc := r.PC
+	if sk.strip&trace.FlagSourceInfo != 0 {
+		pc = 0
+	}
+	panicked := false
+	r.Attrs(func(a trace.Attr) bool {
+		var pe *trace.PanicError
+		panicked = a.Kind() == trace.ErrorKind && errors.As(a.Error(), &pe)
+		return !panicked
+	})
+
+	arr := make([]trace.Attr, 0, r.NumAttrs())
+	r.Attrs(func(a trace.Attr) bool {
+		switch {
+		case sk.strip&trace.FlagGoroutineID != 0 && isGoroutineID(a):
+		case sk.strip&trace.FlagStackTrace != 0 && a.Kind() == trace.StackKind && !panicked:
+		default:
+			arr = append(arr, a)
+		}
+		return true
+	})
+	nr := trace.NewRecord(r.Time, r.Level, r.Message, pc)
+	nr.Site, nr.Trace = r.Site, r.Trace
+	nr.AddAttrs(arr...)
+	return nr
+}
+
+// stripAttrs returns the attrs of a trace notification without the call
+// site and goroutine ID that the Graph captured for other sinks.
+func (sk *sink) stripAttrs(attrs []trace.Attr) []trace.Attr {
+	if sk.strip&(trace.FlagSourceInfo|trace.FlagGoroutineID) == 0 {
+		return attrs
+	}
+	arr := make([]trace.Attr, 0, len(attrs))
+	for _, a := range attrs {
+		switch {
+		case sk.strip&trace.FlagSourceInfo != 0 && a.Kind() == trace.SourceKind:
+		case sk.strip&trace.FlagGoroutineID != 0 && isGoroutineID(a):
+		default:
+			arr = append(arr, a)
+		}
+	}
+	return arr
+}
+
+// isGoroutineID reports whether a is the goroutine ID added by a Tracepoint.
+func isGoroutineID(a trace.Attr) bool {
+	return a.Key() == "gid" && a.Kind() == trace.Uint64Kind
+}
+
+// notifies reports whether the site passes trace notifications to sk. Sinks
+// that write them as events only receive them at DebugLevel.
+func (s *site) notifies(sk *sink) bool {
+	return !sk.events || (trace.DebugLevel <= s.level && trace.DebugLevel <= sk.level)
+}
+
+// Handlers returns the handlers of the sinks.
+func (g *Graph) Handlers() []trace.Handler {
+	arr := make([]trace.Handler, len(g.sinks))
+	for i, sk := range g.sinks {
+		arr[i] = sk.h
+	}
+	return arr
+}
+
+// Flush flushes every sink, and returns the first error.
+func (g *Graph) Flush() error {
+	var err error
+	for _, sk := range g.sinks {
+		if f, ok := sk.h.(trace.Flusher); ok {
+			if ferr := f.Flush(); err == nil {
+				err = ferr
+			}
+		}
+	}
+	return err
+}
+
+// Close flushes and closes every sink, then releases the servers and closes
+// the files that the sinks use. A server keeps listening if a newer Graph
+// listens on its address. The returned error is a *trace.ShutdownError that
+// lists each sink that failed, or nil.
+func (g *Graph) Close() error {
+	var failed []trace.HandlerError
+	g.once.Do(func() {
+		for _, sk := range g.sinks {
+			var err error
+			if f, ok := sk.h.(trace.Flusher); ok {
+				err = f.Flush()
+			}
+			if c, ok := sk.h.(trace.Closer); ok {
+				if cerr := c.Close(); err == nil {
+					err = cerr
+				}
+			}
+			if err != nil {
+				failed = append(failed, trace.HandlerError{Handler: sk.h, Err: err})
+			}
+		}
+		for _, c := range g.closers {
+			c.Close()
+		}
+	})
+	if len(failed) > 0 {
+		return &trace.ShutdownError{Errors: failed}
+	}
+	return nil
+}
+
+// redactRecord returns r with the values of redacted attrs replaced.
+func (g *Graph) redactRecord(r trace.Record) trace.Record {
+	if !g.needsRedaction(r.Attrs) {
+		return r
+	}
+	nr := trace.NewRecord(r.Time, r.Level, r.Message, r.PC)
+	nr.Site, nr.Trace = r.Site, r.Trace
+	r.Attrs(func(a trace.Attr) bool {
+		nr.AddAttrs(g.redactAttr(a))
+		return true
+	})
+	return nr
+}
+
+func (g *Graph) redactAttrs(attrs []trace.Attr) []trace.Attr {
+	each := func(f func(trace.Attr) bool) {
+		for _, a := range attrs {
+			if !f(a) {
+				return
+			}
+		}
+	}
+	if !g.needsRedaction(each) {
+		return attrs
+	}
+	arr := make([]trace.Attr, len(attrs))
+	for i, a := range attrs {
+		arr[i] = g.redactAttr(a)
+	}
+	return arr
+}
+
+// redactTrace returns tr with its attrs redacted, as handlers read them from
+// Trace.Attrs.
+func (g *Graph) redactTrace(tr trace.Trace) trace.Trace {
+	attrs := tr.Attrs()
+	if redacted := g.redactAttrs(attrs); len(attrs) > 0 && &redacted[0] != &attrs[0] {
+		return redactedTrace{tr, redacted}
+	}
+	return tr
+}
+
+func (g *Graph) needsRedaction(each func(func(trace.Attr) bool)) bool {
+	found := false
+	if len(g.redact) > 0 {
+		each(func(a trace.Attr) bool {
+			found = g.redact[a.Key()]
+			return !found
+		})
+	}
+	return found
+}
+
+func (g *Graph) redactAttr(a trace.Attr) trace.Attr {
+	if g.redact[a.Key()] {
+		return trace.String(a.Key(), Redacted)
+	}
+	return a
+}
+
+// redactedTrace is a Trace whose attrs have been redacted.
+type redactedTrace struct {
+	trace.Trace
+	attrs []trace.Attr
+}
+
+func (tr redactedTrace) Attrs() []trace.Attr {
+	return tr.attrs
+}
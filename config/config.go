@@ -0,0 +1,515 @@
+/*
+Package config builds a handler graph from a YAML or JSON description, e.g.
+
+	level: info
+	sinks:
+	  - name: console
+	    type: text
+	    output: stderr
+	  - name: metrics
+	    type: statsd
+	    address: localhost:8125
+	    interval: 10s
+	levels:
+	  - sites: db.*
+	    level: debug
+	sampling:
+	  - sites: http.*
+	    rate: 0.1
+	redact: [password, token]
+	routes:
+	  - sites: db.*
+	    sinks: [console, metrics]
+	  - sites: "*"
+	    sinks: [console]
+
+Sites are selected by patterns that are matched against registry identifiers
+with trace.MatchIdentifier. Since JSON is a subset of YAML, a description may
+be written in either.
+*/
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dzrw/trace"
+	"gopkg.in/yaml.v3"
+)
+
+// Config describes a handler graph. Use Parse or Load to create a Config.
+type Config struct {
+	Level    string         // Level is the default level of every site.
+	Sinks    []Sink         // Sinks are the handlers at the leaves of the graph.
+	Levels   []LevelRule    // Levels override Level for some sites.
+	Sampling []SamplingRule // Sampling keeps a fraction of the traces of some sites.
+	Redact   []string       // Redact lists the keys of attrs whose values are hidden.
+	Routes   []Route        // Routes select the sinks of each site.
+
+	file  string
+	level trace.Level
+}
+
+/*
+Sink describes a handler. The Type selects the handler and the fields that
+apply to it:
+
+	text, json: output, source, goroutine, stack
+	logrus:     output, source, goroutine
+	statsd:     network, address, mtu, interval
+	graphite:   address, prefix, batch
+	influxdb:   url, batch
+	prometheus: listen, buckets
+
+The output is "stdout", "stderr" or the path of a file to append to. Any sink
+may set aggregate to the interval at which it is fed by a trace.Aggregator.
+*/
+type Sink struct {
+	Name      string
+	Type      string
+	Level     string
+	Output    string
+	Source    bool
+	Goroutine bool
+	Stack     bool
+	Network   string
+	Address   string
+	MTU       int
+	Interval  string
+	Prefix    string
+	Batch     int
+	URL       string
+	Listen    string
+	Buckets   []float64
+	Aggregate string
+
+	node      *yaml.Node
+	level     trace.Level
+	interval  time.Duration
+	aggregate time.Duration
+}
+
+// LevelRule sets the level of the sites that match a pattern. When several
+// rules match a site, the last one wins.
+type LevelRule struct {
+	Sites string
+	Level string
+
+	node  *yaml.Node
+	level trace.Level
+}
+
+// SamplingRule keeps the given fraction of the traces of the sites that match
+// a pattern. Events at ErrorLevel and AssertionViolatedLevel are always kept.
+// When several rules match a site, the last one wins.
+type SamplingRule struct {
+	Sites string
+	Rate  float64
+
+	node *yaml.Node
+}
+
+// Route sends the events and metrics of the sites that match a pattern to the
+// named sinks. The first matching route wins; sites that match no route are
+// discarded. If there are no routes, every site is sent to every sink.
+type Route struct {
+	Sites string
+	Sinks []string
+
+	node *yaml.Node
+}
+
+// An Error is a problem with a description at a position in its source.
+type Error struct {
+	File   string // File is the name of the source, or empty.
+	Line   int    // Line is the 1-based line of the problem, or zero.
+	Column int    // Column is the 1-based column of the problem, or zero.
+	Msg    string
+}
+
+func (e *Error) Error() string {
+	sb := strings.Builder{}
+	if e.File != "" {
+		sb.WriteString(e.File)
+		sb.WriteByte(':')
+	}
+	if e.Line > 0 {
+		sb.WriteString(strconv.Itoa(e.Line))
+		sb.WriteByte(':')
+		if e.Column > 0 {
+			sb.WriteString(strconv.Itoa(e.Column))
+			sb.WriteByte(':')
+		}
+	}
+	if sb.Len() > 0 {
+		sb.WriteByte(' ')
+	}
+	sb.WriteString(e.Msg)
+	return sb.String()
+}
+
+// ErrorList is a list of Errors, sorted by position. It is returned by Parse
+// and Load when a description is invalid.
+type ErrorList []*Error
+
+func (el ErrorList) Error() string {
+	switch len(el) {
+	case 0:
+		return "no errors"
+	case 1:
+		return el[0].Error()
+	}
+	return fmt.Sprintf("%s (and %d more errors)", el[0], len(el)-1)
+}
+
+// Is reports whether any error in the list matches target.
+func (el ErrorList) Is(target error) bool {
+	for _, err := range el {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// As finds the first error in the list that matches target.
+func (el ErrorList) As(target any) bool {
+	for _, err := range el {
+		if errors.As(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// Load reads and parses the description in the file at path. Errors are
+// reported with the path.
+func Load(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	c, err := Parse(data)
+	if el, ok := err.(ErrorList); ok {
+		for _, e := range el {
+			e.File = path
+		}
+		return nil, err
+	}
+	if c != nil {
+		c.file = path
+	}
+	return c, err
+}
+
+var yamlLine = regexp.MustCompile(`^(?:yaml: )?line (\d+): `)
+
+// Parse parses and validates a YAML or JSON description. If it is invalid,
+// the returned error is an ErrorList.
+func Parse(data []byte) (*Config, error) {
+	// JSON allows tabs wherever it allows spaces, but YAML does not allow
+	// them in indentation. Outside of strings, where JSON forbids them, tabs
+	// are only whitespace.
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+		data = bytes.ReplaceAll(data, []byte{'\t'}, []byte{' '})
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, ErrorList{syntaxError(err)}
+	}
+
+	p := parser{}
+	c := p.parse(&doc)
+	if len(p.errs) > 0 {
+		sort.SliceStable(p.errs, func(i, j int) bool {
+			if p.errs[i].Line != p.errs[j].Line {
+				return p.errs[i].Line < p.errs[j].Line
+			}
+			return p.errs[i].Column < p.errs[j].Column
+		})
+		return nil, p.errs
+	}
+	return c, nil
+}
+
+func syntaxError(err error) *Error {
+	msg := err.Error()
+	if m := yamlLine.FindStringSubmatch(msg); m != nil {
+		line, _ := strconv.Atoi(m[1])
+		return &Error{Line: line, Msg: msg[len(m[0]):]}
+	}
+	return &Error{Msg: strings.TrimPrefix(msg, "yaml: ")}
+}
+
+// parser decodes a document node by node so that every problem can be
+// reported at its position.
+type parser struct {
+	errs ErrorList
+}
+
+func (p *parser) errorf(n *yaml.Node, format string, args ...interface{}) {
+	p.errs = append(p.errs, &Error{Line: n.Line, Column: n.Column, Msg: fmt.Sprintf(format, args...)})
+}
+
+// fields returns the values of a mapping by key, reporting keys that are not
+// in known.
+func (p *parser) fields(n *yaml.Node, what string, known ...string) map[string]*yaml.Node {
+	if n.Kind != yaml.MappingNode {
+		p.errorf(n, "%s must be a mapping", what)
+		return nil
+	}
+	m := make(map[string]*yaml.Node, len(n.Content)/2)
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		k, v := n.Content[i], n.Content[i+1]
+		switch {
+		case !contains(known, k.Value):
+			p.errorf(k, "unknown field %q in %s", k.Value, what)
+		case m[k.Value] != nil:
+			p.errorf(k, "duplicate field %q in %s", k.Value, what)
+		default:
+			m[k.Value] = v
+		}
+	}
+	return m
+}
+
+func (p *parser) sequence(n *yaml.Node, what string) []*yaml.Node {
+	if n.Kind != yaml.SequenceNode {
+		p.errorf(n, "%s must be a list", what)
+		return nil
+	}
+	return n.Content
+}
+
+// decode decodes a scalar into v, reporting a mismatched type.
+func (p *parser) decode(n *yaml.Node, what string, v interface{}) {
+	if n == nil {
+		return
+	}
+	if err := n.Decode(v); err != nil {
+		p.errorf(n, "invalid %s: %s", what, decodeError(err))
+	}
+}
+
+func decodeError(err error) string {
+	if te, ok := err.(*yaml.TypeError); ok && len(te.Errors) > 0 {
+		return yamlLine.ReplaceAllString(te.Errors[0], "")
+	}
+	return err.Error()
+}
+
+func (p *parser) level(n *yaml.Node, what string) (string, trace.Level) {
+	var s string
+	p.decode(n, what, &s)
+	if n == nil || s == "" {
+		return s, 0
+	}
+	l, err := trace.ParseLevel(s)
+	if err != nil {
+		p.errorf(n, "invalid %s %q", what, s)
+	}
+	return s, l
+}
+
+func (p *parser) duration(n *yaml.Node, what string) (string, time.Duration) {
+	var s string
+	p.decode(n, what, &s)
+	if n == nil || s == "" {
+		return s, 0
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		p.errorf(n, "invalid %s %q", what, s)
+	}
+	return s, d
+}
+
+func (p *parser) pattern(n *yaml.Node, what string) string {
+	var s string
+	p.decode(n, what, &s)
+	if n != nil && s == "" {
+		p.errorf(n, "%s must not be empty", what)
+	}
+	return s
+}
+
+func (p *parser) parse(doc *yaml.Node) *Config {
+	c := &Config{level: trace.InfoLevel}
+	if doc.Kind == 0 || len(doc.Content) == 0 {
+		return c
+	}
+
+	f := p.fields(doc.Content[0], "config", "level", "sinks", "levels", "sampling", "redact", "routes")
+	if s, l := p.level(f["level"], "level"); s != "" {
+		c.Level, c.level = s, l
+	}
+
+	if n := f["sinks"]; n != nil {
+		for _, v := range p.sequence(n, "sinks") {
+			c.Sinks = append(c.Sinks, p.sink(v))
+		}
+	}
+	if n := f["levels"]; n != nil {
+		for _, v := range p.sequence(n, "levels") {
+			r := LevelRule{node: v}
+			g := p.fields(v, "level rule", "sites", "level")
+			r.Sites = p.pattern(g["sites"], "sites")
+			r.Level, r.level = p.level(g["level"], "level")
+			p.require(v, g, "level rule", "sites", "level")
+			c.Levels = append(c.Levels, r)
+		}
+	}
+	if n := f["sampling"]; n != nil {
+		for _, v := range p.sequence(n, "sampling") {
+			r := SamplingRule{node: v}
+			g := p.fields(v, "sampling rule", "sites", "rate")
+			r.Sites = p.pattern(g["sites"], "sites")
+			p.decode(g["rate"], "rate", &r.Rate)
+			if r.Rate < 0 || r.Rate > 1 {
+				p.errorf(g["rate"], "rate must be between 0 and 1")
+			}
+			p.require(v, g, "sampling rule", "sites", "rate")
+			c.Sampling = append(c.Sampling, r)
+		}
+	}
+	if n := f["redact"]; n != nil {
+		for _, v := range p.sequence(n, "redact") {
+			var key string
+			p.decode(v, "redacted key", &key)
+			c.Redact = append(c.Redact, key)
+		}
+	}
+	if n := f["routes"]; n != nil {
+		for _, v := range p.sequence(n, "routes") {
+			c.Routes = append(c.Routes, p.route(v, c.Sinks))
+		}
+	}
+
+	names := map[string]bool{}
+	for _, s := range c.Sinks {
+		if s.Name != "" && names[s.Name] {
+			p.errorf(s.node, "duplicate sink %q", s.Name)
+		}
+		names[s.Name] = true
+	}
+	return c
+}
+
+// require reports the keys in names that are missing from f.
+func (p *parser) require(n *yaml.Node, f map[string]*yaml.Node, what string, names ...string) {
+	if f == nil {
+		return
+	}
+	for _, name := range names {
+		if f[name] == nil {
+			p.errorf(n, "missing field %q in %s", name, what)
+		}
+	}
+}
+
+var sinkFields = map[string][]string{
+	"text":       {"output", "source", "goroutine", "stack"},
+	"json":       {"output", "source", "goroutine", "stack"},
+	"logrus":     {"output", "source", "goroutine"},
+	"statsd":     {"network", "address", "mtu", "interval"},
+	"graphite":   {"address", "prefix", "batch"},
+	"influxdb":   {"url", "batch"},
+	"prometheus": {"listen", "buckets"},
+}
+
+func (p *parser) sink(n *yaml.Node) Sink {
+	s := Sink{node: n}
+
+	var typ string
+	if n.Kind == yaml.MappingNode {
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			if n.Content[i].Value == "type" {
+				p.decode(n.Content[i+1], "type", &typ)
+				if _, ok := sinkFields[typ]; !ok {
+					p.errorf(n.Content[i+1], "unknown sink type %q", typ)
+					return s
+				}
+			}
+		}
+	}
+
+	known := append([]string{"name", "type", "level", "aggregate"}, sinkFields[typ]...)
+	f := p.fields(n, "sink", known...)
+	if f == nil {
+		return s
+	}
+	p.require(n, f, "sink", "name", "type")
+
+	s.Type = typ
+	p.decode(f["name"], "name", &s.Name)
+	s.Level, s.level = p.level(f["level"], "level")
+	s.Aggregate, s.aggregate = p.duration(f["aggregate"], "aggregate")
+	p.decode(f["output"], "output", &s.Output)
+	p.decode(f["source"], "source", &s.Source)
+	p.decode(f["goroutine"], "goroutine", &s.Goroutine)
+	p.decode(f["stack"], "stack", &s.Stack)
+	p.decode(f["network"], "network", &s.Network)
+	p.decode(f["address"], "address", &s.Address)
+	p.decode(f["mtu"], "mtu", &s.MTU)
+	s.Interval, s.interval = p.duration(f["interval"], "interval")
+	p.decode(f["prefix"], "prefix", &s.Prefix)
+	p.decode(f["batch"], "batch", &s.Batch)
+	p.decode(f["url"], "url", &s.URL)
+	p.decode(f["listen"], "listen", &s.Listen)
+	p.decode(f["buckets"], "buckets", &s.Buckets)
+
+	switch typ {
+	case "statsd", "graphite":
+		p.require(n, f, "sink", "address")
+	case "influxdb":
+		p.require(n, f, "sink", "url")
+	}
+	if s.level == 0 {
+		s.level = trace.NoiseLevel
+	}
+	return s
+}
+
+func (p *parser) route(n *yaml.Node, sinks []Sink) Route {
+	r := Route{node: n}
+	f := p.fields(n, "route", "sites", "sinks")
+	r.Sites = p.pattern(f["sites"], "sites")
+	p.require(n, f, "route", "sites", "sinks")
+	if v := f["sinks"]; v != nil {
+		for _, e := range p.sequence(v, "sinks") {
+			var name string
+			p.decode(e, "sink name", &name)
+			if !hasSink(sinks, name) {
+				p.errorf(e, "unknown sink %q", name)
+			}
+			r.Sinks = append(r.Sinks, name)
+		}
+	}
+	return r
+}
+
+func hasSink(sinks []Sink, name string) bool {
+	for _, s := range sinks {
+		if s.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(arr []string, s string) bool {
+	for _, v := range arr {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,289 @@
+package config_test
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/dzrw/trace"
+	"github.com/dzrw/trace/config"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	SiteTestQuery  = trace.Site()
+	SiteTestHealth = trace.Site()
+	SiteTestHTTP   = trace.Site()
+	SiteTestFlags  = trace.Site()
+)
+
+func testRegistry() trace.Registry {
+	reg := trace.NewRegistry()
+	reg.Define(SiteTestQuery, "db.query")
+	reg.Define(SiteTestHealth, "db.health")
+	reg.Define(SiteTestHTTP, "http.request")
+	reg.Define(SiteTestFlags, "flags")
+	return reg
+}
+
+func TestParseErrors(t *testing.T) {
+	_, err := config.Parse([]byte(`level: info
+sinks:
+  - name: console
+    type: text
+    colour: true
+  - name: metrics
+    type: carrier-pigeon
+levels:
+  - sites: db.*
+    level: loud
+routes:
+  - sites: "*"
+    sinks: [console, consol]
+`))
+
+	var el config.ErrorList
+	require.True(t, errors.As(err, &el))
+	require.Equal(t, []string{
+		`5:5: unknown field "colour" in sink`,
+		`7:11: unknown sink type "carrier-pigeon"`,
+		`10:12: invalid level "loud"`,
+		`13:22: unknown sink "consol"`,
+	}, errorStrings(el))
+}
+
+func TestParseSyntaxError(t *testing.T) {
+	_, err := config.Parse([]byte("level: info\nsinks: [\n"))
+	var el config.ErrorList
+	require.True(t, errors.As(err, &el))
+	require.Len(t, el, 1)
+	require.Equal(t, 2, el[0].Line)
+
+	var e *config.Error
+	require.True(t, errors.As(err, &e))
+	require.Same(t, el[0], e)
+}
+
+func TestParseJSON(t *testing.T) {
+	c, err := config.Parse([]byte("{\n\t\"level\": \"warn\",\n\t\"sinks\": [{\"name\": \"console\", \"type\": \"json\"}],\n\t\"redact\": [\"password\"]\n}\n"))
+	require.NoError(t, err)
+	require.Equal(t, "warn", c.Level)
+	require.Equal(t, "json", c.Sinks[0].Type)
+	require.Equal(t, []string{"password"}, c.Redact)
+
+	_, err = config.Parse([]byte("{\n\t\"level\": \"warn\",\n\t\"sinks\": [{\"name\": \"console\"}]\n}\n"))
+	require.EqualError(t, err, `3:12: missing field "type" in sink`)
+}
+
+func TestLoadErrorHasPath(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "trace.yaml")
+	require.NoError(t, os.WriteFile(path, []byte("level: info\nlevles: []\n"), 0o644))
+
+	_, err := config.Load(path)
+	require.EqualError(t, err, path+`:2:1: unknown field "levles" in config`)
+}
+
+func TestBuild(t *testing.T) {
+	dir := t.TempDir()
+	all, db := filepath.Join(dir, "all.log"), filepath.Join(dir, "db.log")
+
+	c, err := config.Parse([]byte(`level: info
+sinks:
+  - name: all
+    type: text
+    output: ` + all + `
+  - name: db
+    type: json
+    output: ` + db + `
+levels:
+  - sites: db.*
+    level: debug
+  - sites: db.health
+    level: error
+sampling:
+  - sites: http.*
+    rate: 0
+redact: [password]
+routes:
+  - sites: db.*
+    sinks: [all, db]
+  - sites: "*"
+    sinks: [all]
+`))
+	require.NoError(t, err)
+
+	g, err := c.Build(testRegistry())
+	require.NoError(t, err)
+	sb := trace.NewSwitchboard(g)
+	for _, tp := range []trace.Tracepoint{SiteTestQuery, SiteTestHealth, SiteTestHTTP} {
+		tp.Install(sb)
+		defer tp.Uninstall()
+	}
+
+	tr := SiteTestQuery.Trace(trace.String("password", "hunter2"))
+	tr.Debug("querying")
+	tr.Log(trace.NoiseLevel, trace.Event("ignored"))
+	SiteTestHealth.Trace().Warn("ignored")
+	SiteTestHealth.Trace().Error("unhealthy")
+	tr = SiteTestHTTP.Trace()
+	tr.Info("sampled out")
+	tr.Error("kept")
+	require.NoError(t, sb.Close())
+
+	require.Equal(t, strings.Join([]string{
+		`site=db.query trace=0 event="trace created" password=[REDACTED]`,
+		`site=db.query trace=0 password=[REDACTED] event=querying`,
+		`site=db.health trace=1 event=unhealthy`,
+		`site=http.request trace=0 event=kept`,
+		``,
+	}, "\n"), readFile(t, all))
+
+	lines := strings.Split(readFile(t, db), "\n")
+	require.Len(t, lines, 4)
+	require.Contains(t, lines[1], `"level":"DEBUG","site":"db.query","trace":0,"password":"[REDACTED]","event":"querying"}`)
+	require.Contains(t, lines[2], `"level":"ERROR","site":"db.health","trace":1,"event":"unhealthy"}`)
+}
+
+func TestBuildSinkFlags(t *testing.T) {
+	dir := t.TempDir()
+	plain, rich := filepath.Join(dir, "plain.log"), filepath.Join(dir, "rich.log")
+
+	c, err := config.Parse([]byte(`level: debug
+sinks:
+  - name: plain
+    type: text
+    output: ` + plain + `
+  - name: rich
+    type: text
+    output: ` + rich + `
+    source: true
+    goroutine: true
+    stack: true
+`))
+	require.NoError(t, err)
+
+	g, err := c.Build(testRegistry())
+	require.NoError(t, err)
+	SiteTestFlags.Install(g)
+	defer SiteTestFlags.Uninstall()
+
+	trace.SetPanicPolicy(trace.PanicSwallow)
+	defer trace.SetPanicPolicy(trace.PanicPropagate)
+
+	tr := SiteTestFlags.Trace()
+	tr.Error("failed")
+	func() {
+		defer SiteTestFlags.Trace().Recover()
+		panic("boom")
+	}()
+	tr.Close()
+	require.NoError(t, g.Close())
+
+	// Only the sink that asked for them gets call sites, goroutine IDs and
+	// stack traces, except for the stack trace of a panic.
+	lines := strings.Split(readFile(t, plain), "\n")
+	for _, line := range lines {
+		require.NotContains(t, line, "source=")
+		require.NotContains(t, line, "gid=")
+	}
+	require.Contains(t, lines[1], "event=failed")
+	require.NotContains(t, lines[1], "stack=")
+	require.Contains(t, lines[3], "event=panic")
+	require.Contains(t, lines[3], "stack=")
+
+	lines = strings.Split(readFile(t, rich), "\n")
+	require.Contains(t, lines[0], "source=")
+	require.Contains(t, lines[1], "gid=")
+	require.Contains(t, lines[1], "stack=")
+}
+
+func TestBuildError(t *testing.T) {
+	c, err := config.Parse([]byte(`sinks:
+  - name: all
+    type: text
+    output: ` + filepath.Join(t.TempDir(), "missing", "all.log") + `
+`))
+	require.NoError(t, err)
+
+	_, err = c.Build(nil)
+	require.Error(t, err)
+	require.True(t, strings.HasPrefix(err.Error(), `2:5: sink "all": `), err.Error())
+}
+
+func TestWatch(t *testing.T) {
+	dir := t.TempDir()
+	path, out := filepath.Join(dir, "trace.yaml"), filepath.Join(dir, "out.log")
+	write := func(level string) {
+		require.NoError(t, os.WriteFile(path, []byte("level: "+level+"\nsinks:\n  - name: all\n    type: text\n    output: "+out+"\n"), 0o644))
+	}
+
+	write("info")
+	sb := trace.NewSwitchboard(nil)
+	w, err := config.Watch(path, testRegistry(), sb, 0, nil)
+	require.NoError(t, err)
+	defer w.Close()
+
+	require.True(t, sb.Enabled(trace.InfoLevel))
+	require.False(t, sb.Enabled(trace.DebugLevel))
+
+	write("debug")
+	require.NoError(t, w.Reload())
+	require.True(t, sb.Enabled(trace.DebugLevel))
+
+	write("loud")
+	require.EqualError(t, w.Reload(), path+`:1:8: invalid level "loud"`)
+	require.True(t, sb.Enabled(trace.DebugLevel))
+	require.NoError(t, sb.Close())
+}
+
+func TestReloadListen(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := ln.Addr().String()
+	ln.Close()
+
+	path := filepath.Join(t.TempDir(), "trace.yaml")
+	write := func(level string) {
+		require.NoError(t, os.WriteFile(path, []byte("level: "+level+"\nsinks:\n  - name: metrics\n    type: prometheus\n    listen: "+addr+"\n"), 0o644))
+	}
+	scrape := func() int {
+		resp, err := http.Get("http://" + addr + "/metrics")
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	write("info")
+	sb := trace.NewSwitchboard(nil)
+	require.NoError(t, config.Apply(path, testRegistry(), sb))
+	require.Equal(t, http.StatusOK, scrape())
+
+	// The new graph takes over the address of the old one.
+	write("debug")
+	require.NoError(t, config.Apply(path, testRegistry(), sb))
+	require.Equal(t, http.StatusOK, scrape())
+
+	// The address is released with the last graph that listens on it.
+	require.NoError(t, sb.Close())
+	ln, err = net.Listen("tcp", addr)
+	require.NoError(t, err)
+	ln.Close()
+}
+
+func errorStrings(el config.ErrorList) []string {
+	arr := make([]string, len(el))
+	for i, e := range el {
+		arr[i] = e.Error()
+	}
+	return arr
+}
+
+func readFile(t *testing.T, path string) string {
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	return string(data)
+}
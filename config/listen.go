@@ -0,0 +1,92 @@
+package config
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// listeners holds the HTTP servers of the sinks that listen, by address.
+// They outlive the Graph that opened them, so that the Graph built by a
+// reload can take over an address before the previous Graph is closed.
+var listeners = struct {
+	sync.Mutex
+	m map[string]*listener
+}{m: make(map[string]*listener)}
+
+// listener is an HTTP server shared by the Graphs that listen on its
+// address. It serves the handler of the most recent lease.
+type listener struct {
+	addr   string
+	srv    *http.Server
+	leases []*lease
+}
+
+// lease is a Graph's hold on a listener. Closing it releases the hold, and
+// shuts the server down once no lease remains.
+type lease struct {
+	l *listener
+	h http.Handler
+}
+
+// listen returns a lease on the server listening on addr, which serves h
+// until a later lease is taken. The server is started if there is none.
+func listen(addr string, h http.Handler) (*lease, error) {
+	listeners.Lock()
+	defer listeners.Unlock()
+
+	l, ok := listeners.m[addr]
+	if !ok {
+		ln, err := net.Listen("tcp", addr)
+		if err != nil {
+			return nil, err
+		}
+		l = &listener{addr: addr}
+		l.srv = &http.Server{Handler: l, ReadHeaderTimeout: 10 * time.Second}
+		go l.srv.Serve(ln)
+		listeners.m[addr] = l
+	}
+	ls := &lease{l: l, h: h}
+	l.leases = append(l.leases, ls)
+	return ls, nil
+}
+
+func (l *listener) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	listeners.Lock()
+	var h http.Handler
+	if n := len(l.leases); n > 0 {
+		h = l.leases[n-1].h
+	}
+	listeners.Unlock()
+
+	if h == nil {
+		http.Error(w, "shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	h.ServeHTTP(w, r)
+}
+
+func (ls *lease) Close() error {
+	listeners.Lock()
+	l := ls.l
+	for i, v := range l.leases {
+		if v == ls {
+			l.leases = append(l.leases[:i], l.leases[i+1:]...)
+			break
+		}
+	}
+	last := len(l.leases) == 0
+	if last && listeners.m[l.addr] == l {
+		delete(listeners.m, l.addr)
+	}
+	listeners.Unlock()
+
+	if !last {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return l.srv.Shutdown(ctx)
+}
@@ -0,0 +1,144 @@
+package config
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/dzrw/trace"
+)
+
+// Apply loads the description in the file at path, builds its Graph and
+// swaps it into sb. The previous graph is drained by the Switchboard.
+func Apply(path string, reg trace.Registry, sb *trace.Switchboard) error {
+	c, err := Load(path)
+	if err != nil {
+		return err
+	}
+	g, err := c.Build(reg)
+	if err != nil {
+		return err
+	}
+	return sb.Swap(context.Background(), g)
+}
+
+/*
+Watcher reapplies a description to a Switchboard when the process receives
+SIGHUP or when the file changes. A description that fails to load or build
+leaves the current graph in place. Usage:
+
+	sb := trace.NewSwitchboard(nil)
+	w, err := config.Watch("trace.yaml", reg, sb, time.Second, nil)
+	if err != nil {
+		return err // e.g. trace.yaml:12:5: unknown sink "consol"
+	}
+	defer w.Close()
+*/
+type Watcher struct {
+	path    string
+	reg     trace.Registry
+	sb      *trace.Switchboard
+	onError func(error)
+
+	mu    sync.Mutex
+	mtime time.Time
+	size  int64
+
+	stop chan struct{}
+	done chan struct{}
+	once sync.Once
+}
+
+// Watch applies the description in the file at path to sb, and returns a
+// Watcher that reapplies it on SIGHUP and, if interval is positive, when the
+// file's modification time or size changes, checked every interval. Errors
+// that occur when reapplying are passed to onError, or, if onError is nil,
+// reported against sb with trace.ReportError as the op "Reload".
+func Watch(path string, reg trace.Registry, sb *trace.Switchboard, interval time.Duration, onError func(error)) (*Watcher, error) {
+	w := &Watcher{
+		path:    path,
+		reg:     reg,
+		sb:      sb,
+		onError: onError,
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	if err := w.Reload(); err != nil {
+		return nil, err
+	}
+	go w.run(interval)
+	return w, nil
+}
+
+func (w *Watcher) run(interval time.Duration) {
+	defer close(w.done)
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	var tick <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-hup:
+			w.report(w.Reload())
+		case <-tick:
+			if w.changed() {
+				w.report(w.Reload())
+			}
+		case <-w.stop:
+			return
+		}
+	}
+}
+
+func (w *Watcher) report(err error) {
+	if err == nil {
+		return
+	}
+	if w.onError != nil {
+		w.onError(err)
+		return
+	}
+	trace.ReportError(w.sb, "Reload", err)
+}
+
+// changed reports whether the file differs from the one last applied.
+func (w *Watcher) changed() bool {
+	fi, err := os.Stat(w.path)
+	if err != nil {
+		return false
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return !fi.ModTime().Equal(w.mtime) || fi.Size() != w.size
+}
+
+// Reload applies the file now.
+func (w *Watcher) Reload() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	// Record the file's state first so that a failed reload is not retried
+	// until the file changes again.
+	if fi, err := os.Stat(w.path); err == nil {
+		w.mtime, w.size = fi.ModTime(), fi.Size()
+	}
+	return Apply(w.path, w.reg, w.sb)
+}
+
+// Close stops watching. It leaves the Switchboard's graph in place.
+func (w *Watcher) Close() error {
+	w.once.Do(func() { close(w.stop) })
+	<-w.done
+	return nil
+}
@@ -10,7 +10,55 @@ func NewContext(parent context.Context, tr Trace) context.Context {
 	return context.WithValue(parent, traceKey, tr)
 }
 
+// FromContext returns the Trace stored in ctx by NewContext, if any.
 func FromContext(ctx context.Context) (s Trace, ok bool) {
-	v, ok := ctx.Value(traceKey).(*traceimpl)
-	return v, ok
+	v, ok := ctx.Value(traceKey).(Trace)
+	return v, ok && v != nil
+}
+
+// From returns the Trace stored in ctx by NewContext, or a Trace that
+// discards everything if there is none.
+func From(ctx context.Context) Trace {
+	if tr, ok := FromContext(ctx); ok {
+		return tr
+	}
+	return noptrace
+}
+
+// LogError captures an event associated with the trace in ctx at the Error
+// level. It is named so because Error constructs an error Attr.
+func LogError(ctx context.Context, event string, attrs ...Attr) {
+	logContext(ctx, ErrorLevel, event, attrs)
+}
+
+// Warn captures an event associated with the trace in ctx.
+func Warn(ctx context.Context, event string, attrs ...Attr) {
+	logContext(ctx, WarnLevel, event, attrs)
+}
+
+// Info captures an event associated with the trace in ctx.
+func Info(ctx context.Context, event string, attrs ...Attr) {
+	logContext(ctx, InfoLevel, event, attrs)
+}
+
+// Debug captures an event associated with the trace in ctx.
+func Debug(ctx context.Context, event string, attrs ...Attr) {
+	logContext(ctx, DebugLevel, event, attrs)
+}
+
+// Log an event associated with the trace in ctx.
+func Log(ctx context.Context, level Level, attrs ...Attr) {
+	logContext(ctx, level, "", attrs)
+}
+
+func logContext(ctx context.Context, level Level, msg string, attrs []Attr) {
+	switch tr := From(ctx).(type) {
+	case *traceimpl:
+		tr.tp.log(ctx, tr, 3, level, msg, attrs)
+	default:
+		if msg != "" {
+			attrs = append(attrs, Event(msg))
+		}
+		tr.Log(level, attrs...)
+	}
 }
@@ -0,0 +1,91 @@
+package trace_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/dzrw/trace"
+	"github.com/stretchr/testify/require"
+)
+
+var SiteTestContext = trace.Site()
+
+func TestFromContext(t *testing.T) {
+	_, ok := trace.FromContext(context.Background())
+	require.False(t, ok)
+
+	tr := trace.From(context.Background())
+	require.NotNil(t, tr)
+	require.Nil(t, tr.Site())
+	tr.Info("discarded")
+
+	// An uninstalled site originates a noop trace, which is still stored.
+	nop := SiteTestContext.Trace()
+	got, ok := trace.FromContext(trace.NewContext(context.Background(), nop))
+	require.True(t, ok)
+	require.Equal(t, nop, got)
+}
+
+func TestContextHelpers(t *testing.T) {
+	reg := trace.NewRegistry()
+	reg.Define(SiteTestContext, "trace_test.SiteTestContext")
+
+	buf := bytes.Buffer{}
+	SiteTestContext.Install(trace.NewTextHandler(&buf, trace.DebugLevel, true, false, reg))
+	defer SiteTestContext.Uninstall()
+
+	tr := SiteTestContext.Trace()
+	ctx := trace.NewContext(context.Background(), tr)
+	buf.Reset()
+
+	trace.Info(ctx, "hello", trace.Int("n", 1))
+	require.Regexp(t, `^site=trace_test.SiteTestContext trace=\d+ n=1 event=hello source=github.com/dzrw/trace_test.TestContextHelpers\(\S+/context_test.go:\d+\)\n$`, buf.String())
+
+	buf.Reset()
+	trace.LogError(context.Background(), "discarded")
+	require.Empty(t, buf.String())
+}
+
+type tenantKey struct{}
+
+type contextRecorder struct {
+	recordRecorder
+	tenants []string
+}
+
+func (h *contextRecorder) Log(trace.Trace, trace.Level, ...[]trace.Attr) error { return nil }
+
+func (h *contextRecorder) EnabledContext(ctx context.Context, l trace.Level) bool {
+	return ctx.Value(tenantKey{}) != "muted"
+}
+
+func (h *contextRecorder) HandleContext(ctx context.Context, r trace.Record) error {
+	h.tenants = append(h.tenants, "event:"+ctx.Value(tenantKey{}).(string))
+	return nil
+}
+
+func (h *contextRecorder) TraceCreatedContext(ctx context.Context, tr trace.Trace, attrs []trace.Attr) {
+	h.tenants = append(h.tenants, "created:"+ctx.Value(tenantKey{}).(string))
+}
+
+func (h *contextRecorder) TraceFinishedContext(ctx context.Context, tr trace.Trace, attrs []trace.Attr) {
+	h.tenants = append(h.tenants, "finished:"+ctx.Value(tenantKey{}).(string))
+}
+
+func TestContextHandler(t *testing.T) {
+	h := &contextRecorder{}
+	SiteTestContext.Install(trace.NewAggregator(h, 0))
+	defer SiteTestContext.Uninstall()
+
+	ctx := context.WithValue(context.Background(), tenantKey{}, "t1")
+	tr := SiteTestContext.TraceContext(ctx)
+	tr.With(trace.Int("n", 1)).Info("hello")
+
+	muted := context.WithValue(trace.NewContext(ctx, tr), tenantKey{}, "muted")
+	trace.Info(muted, "dropped")
+	trace.Info(trace.NewContext(context.WithValue(ctx, tenantKey{}, "t2"), tr), "helper")
+	tr.Close()
+
+	require.Equal(t, []string{"created:t1", "event:t1", "event:t2", "finished:t1"}, h.tenants)
+}
@@ -0,0 +1,154 @@
+package trace
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync/atomic"
+)
+
+// defaultLevel is the level above which events are dropped by every
+// Tracepoint, before they reach a Handler. Unless it is NoiseLevel, it
+// replaces the levels of the handlers.
+var defaultLevel = int32(NoiseLevel)
+
+// SetDefaultLevel sets the least severe level of the events that Tracepoints
+// pass to their handlers. It replaces the levels of the handlers, so it can
+// make a Handler more verbose as well as quieter: an event is handled if it
+// passes the default level, whatever the Handler's own level. Traces are
+// always passed to handlers, so that failed traces are counted whatever the
+// level. The default is NoiseLevel, which leaves the choice to the handlers.
+func SetDefaultLevel(l Level) {
+	atomic.StoreInt32(&defaultLevel, int32(l))
+}
+
+// DefaultLevel returns the level set by SetDefaultLevel.
+func DefaultLevel() Level {
+	return Level(atomic.LoadInt32(&defaultLevel))
+}
+
+// SetSiteEnabled enables or disables the events of a Tracepoint created by
+// Site. The events of a disabled site are dropped before they reach its
+// Handler; its traces and metrics are unaffected. Sites are enabled by
+// default.
+func SetSiteEnabled(tp Tracepoint, enabled bool) {
+	if tp, ok := tp.(*tracepoint); ok {
+		var disabled uint32
+		if !enabled {
+			disabled = 1
+		}
+		atomic.StoreUint32(&tp.disabled, disabled)
+	}
+}
+
+// SiteEnabled reports whether the events of tp are enabled.
+func SiteEnabled(tp Tracepoint) bool {
+	if tp, ok := tp.(*tracepoint); ok {
+		return atomic.LoadUint32(&tp.disabled) == 0
+	}
+	return true
+}
+
+// enabled reports whether tp passes events at level to ch. The default level,
+// unless it is NoiseLevel, is used instead of the level of ch.
+func (tp *tracepoint) enabled(ctx context.Context, ch ContextHandler, level Level) bool {
+	if atomic.LoadUint32(&tp.disabled) != 0 {
+		return false
+	}
+	if dl := DefaultLevel(); dl != NoiseLevel {
+		return level <= dl
+	}
+	return ch.EnabledContext(ctx, level)
+}
+
+/*
+ConfigureFromEnv applies the environment variables TRACE_LEVEL and
+TRACE_SITES to every Tracepoint created by Site. Sites are identified by reg.
+
+TRACE_LEVEL is a level accepted by ParseLevel, e.g. "debug", which is passed
+to SetDefaultLevel. It is used instead of the levels of the handlers, so it
+turns logging up as well as down without a rebuild.
+
+TRACE_SITES is a list of patterns that is passed to EnableSites, e.g.
+
+	TRACE_SITES=db.*,-db.health
+
+Unset variables are ignored. An invalid TRACE_LEVEL is returned as an error
+and leaves the sites unchanged.
+*/
+func ConfigureFromEnv(reg Registry) error {
+	if v, ok := os.LookupEnv("TRACE_LEVEL"); ok {
+		l, err := ParseLevel(v)
+		if err != nil {
+			return fmt.Errorf("trace: TRACE_LEVEL: %w", err)
+		}
+		SetDefaultLevel(l)
+	}
+
+	if v, ok := os.LookupEnv("TRACE_SITES"); ok {
+		EnableSites(reg, v)
+	}
+	return nil
+}
+
+/*
+EnableSites enables or disables every Tracepoint created by Site according to
+a comma-separated list of patterns, as accepted by MatchIdentifier, which are
+matched against the identifiers defined by reg. A pattern enables the
+matching sites; a pattern prefixed with '-' disables them instead. When
+several patterns match a site, the last one wins. If the list has any
+enabling pattern, the sites that match no pattern are disabled; otherwise they
+are enabled. For example,
+
+	db.*,-db.health
+
+enables the sites whose identifiers begin with "db." except "db.health", and
+disables every other site.
+*/
+func EnableSites(reg Registry, patterns string) {
+	if reg == nil {
+		reg = NewRegistry()
+	}
+	sl := parseSiteList(patterns)
+	for _, tp := range Sites() {
+		id, _ := reg.IdentifierFor(tp)
+		SetSiteEnabled(tp, sl.enabled(id))
+	}
+}
+
+// siteList is a parsed TRACE_SITES.
+type siteList []sitePattern
+
+type sitePattern struct {
+	pattern string
+	enable  bool
+}
+
+func parseSiteList(s string) siteList {
+	var arr siteList
+	for _, p := range strings.Split(s, ",") {
+		p = strings.TrimSpace(p)
+		enable := !strings.HasPrefix(p, "-")
+		if p = strings.TrimPrefix(p, "-"); p != "" {
+			arr = append(arr, sitePattern{p, enable})
+		}
+	}
+	return arr
+}
+
+func (sl siteList) enabled(id string) bool {
+	enabled := true
+	for _, p := range sl {
+		if p.enable {
+			enabled = false
+			break
+		}
+	}
+	for _, p := range sl {
+		if MatchIdentifier(p.pattern, id) {
+			enabled = p.enable
+		}
+	}
+	return enabled
+}
@@ -0,0 +1,101 @@
+package trace_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/dzrw/trace"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	SiteTestEnvQuery  = trace.Site()
+	SiteTestEnvHealth = trace.Site()
+	SiteTestEnvHTTP   = trace.Site()
+	SiteTestEnvFailed = trace.Site()
+)
+
+func TestConfigureFromEnv(t *testing.T) {
+	reg := trace.NewRegistry()
+	reg.Define(SiteTestEnvQuery, "db.query")
+	reg.Define(SiteTestEnvHealth, "db.health")
+	reg.Define(SiteTestEnvHTTP, "http.request")
+
+	t.Setenv("TRACE_LEVEL", "info")
+	t.Setenv("TRACE_SITES", "db.*,-db.health")
+	require.NoError(t, trace.ConfigureFromEnv(reg))
+	defer func() {
+		trace.SetDefaultLevel(trace.NoiseLevel)
+		for _, tp := range trace.Sites() {
+			trace.SetSiteEnabled(tp, true)
+		}
+	}()
+
+	require.Equal(t, trace.InfoLevel, trace.DefaultLevel())
+	require.True(t, trace.SiteEnabled(SiteTestEnvQuery))
+	require.False(t, trace.SiteEnabled(SiteTestEnvHealth))
+	require.False(t, trace.SiteEnabled(SiteTestEnvHTTP))
+
+	buf := bytes.Buffer{}
+	h := trace.NewTextHandler(&buf, trace.DebugLevel, false, false, reg)
+	for _, tp := range []trace.Tracepoint{SiteTestEnvQuery, SiteTestEnvHealth, SiteTestEnvHTTP} {
+		tp.Install(h)
+		defer tp.Uninstall()
+	}
+
+	tr := SiteTestEnvQuery.Trace()
+	tr.Debug("dropped")
+	tr.Info("kept")
+	SiteTestEnvHealth.Trace().Error("dropped")
+	SiteTestEnvHTTP.Count(1)
+	require.Equal(t, "site=db.query trace=0 event=\"trace created\"\n"+
+		"site=db.query trace=0 event=kept\n"+
+		"site=db.health trace=0 event=\"trace created\"\n"+
+		"site=http.request count=1\n", buf.String())
+
+	// The default level replaces the level of a handler.
+	buf.Reset()
+	SiteTestEnvQuery.Install(trace.NewTextHandler(&buf, trace.WarnLevel, false, false, reg))
+	tr.Debug("dropped")
+	tr.Info("kept")
+	require.Equal(t, "site=db.query trace=0 event=kept\n", buf.String())
+
+	t.Setenv("TRACE_LEVEL", "loud")
+	require.Error(t, trace.ConfigureFromEnv(reg))
+	require.Equal(t, trace.InfoLevel, trace.DefaultLevel())
+
+	t.Setenv("TRACE_LEVEL", "debug")
+	t.Setenv("TRACE_SITES", "-db.*")
+	require.NoError(t, trace.ConfigureFromEnv(reg))
+	require.Equal(t, trace.DebugLevel, trace.DefaultLevel())
+	require.False(t, trace.SiteEnabled(SiteTestEnvQuery))
+	require.True(t, trace.SiteEnabled(SiteTestEnvHTTP))
+
+	SiteTestEnvHTTP.Install(trace.NewTextHandler(&buf, trace.WarnLevel, false, false, reg))
+	tr = SiteTestEnvHTTP.Trace()
+	buf.Reset()
+	tr.Debug("kept")
+	require.Equal(t, "site=http.request trace=0 event=kept\n", buf.String())
+
+	// NoiseLevel leaves the choice to the handlers.
+	buf.Reset()
+	trace.SetDefaultLevel(trace.NoiseLevel)
+	tr.Info("dropped")
+	require.Empty(t, buf.String())
+}
+
+func TestDefaultLevelCountsFailedTraces(t *testing.T) {
+	trace.SetDefaultLevel(trace.InfoLevel)
+	defer trace.SetDefaultLevel(trace.NoiseLevel)
+
+	h := trace.NewAggregator(trace.NewTextHandler(io.Discard, trace.InfoLevel, false, false, nil), 0)
+	SiteTestEnvFailed.Install(h)
+	defer SiteTestEnvFailed.Uninstall()
+
+	SiteTestEnvFailed.Trace().CloseWithError(errors.New("failed"))
+	snap := h.Snapshot()
+	require.Len(t, snap, 1)
+	require.Equal(t, int64(1), snap[0].Errors)
+}
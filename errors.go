@@ -0,0 +1,137 @@
+package trace
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// An ErrorHandler is notified when a Handler fails. The op is the name of the
+// Handler method that failed, e.g. "Log" or "Count".
+type ErrorHandler interface {
+	HandleError(h Handler, op string, err error)
+}
+
+// The ErrorHandlerFunc type is an adapter to allow the use of ordinary
+// functions as ErrorHandlers.
+type ErrorHandlerFunc func(h Handler, op string, err error)
+
+// HandleError calls f(h, op, err).
+func (f ErrorHandlerFunc) HandleError(h Handler, op string, err error) {
+	f(h, op, err)
+}
+
+var (
+	errorHandler atomic.Value // holds an errorhandlerbox
+	errorCounts  sync.Map     // map[Handler]*uint64; see forgetErrors
+)
+
+type errorhandlerbox struct {
+	eh ErrorHandler
+}
+
+// SetErrorHandler installs eh as the process-wide ErrorHandler. If eh is nil,
+// errors are written to stderr, at most once per second.
+func SetErrorHandler(eh ErrorHandler) {
+	errorHandler.Store(errorhandlerbox{eh})
+}
+
+// ReportError counts err against h and passes it to the ErrorHandler.
+// Tracepoints report the errors returned by Handler methods; Handlers report
+// the errors that their methods cannot return, such as those of TraceCreated
+// or of a background flush.
+func ReportError(h Handler, op string, err error) {
+	if err == nil {
+		return
+	}
+
+	if h != nil && reflect.TypeOf(h).Comparable() {
+		v, ok := errorCounts.Load(h)
+		if !ok {
+			v, _ = errorCounts.LoadOrStore(h, new(uint64))
+		}
+		atomic.AddUint64(v.(*uint64), 1)
+	}
+
+	if b, _ := errorHandler.Load().(errorhandlerbox); b.eh != nil {
+		b.eh.HandleError(h, op, err)
+		return
+	}
+	stderr.HandleError(h, op, err)
+}
+
+// SiteErrors returns the number of errors reported for the Handler installed
+// into tp and, if it is a HandlerGroup, for the handlers of the group. The
+// errors of a Handler installed into several sites are counted once, against
+// every one of them.
+func SiteErrors(tp Tracepoint) uint64 {
+	if h, ok := tp.Handler(); ok {
+		return handlerErrors(h)
+	}
+	return 0
+}
+
+// handlerErrors returns the number of errors reported for h and the handlers
+// of its group.
+func handlerErrors(h Handler) uint64 {
+	var n uint64
+	if v, ok := errorCounts.Load(h); ok {
+		n = atomic.LoadUint64(v.(*uint64))
+	}
+	if hg, ok := h.(HandlerGroup); ok {
+		for _, h := range hg.Handlers() {
+			n += handlerErrors(h)
+		}
+	}
+	return n
+}
+
+// forgetErrors drops the counts of h and the handlers of its group, once h
+// has been closed, so that the handlers replaced by a reload are not kept.
+func forgetErrors(h Handler) {
+	if hg, ok := h.(HandlerGroup); ok {
+		for _, h := range hg.Handlers() {
+			forgetErrors(h)
+		}
+	}
+	if reflect.TypeOf(h).Comparable() {
+		errorCounts.Delete(h)
+	}
+}
+
+// stderr is the fallback ErrorHandler.
+var stderr = &ratelimitedWriter{w: os.Stderr, every: time.Second}
+
+// ratelimitedWriter is an ErrorHandler that writes at most one error per
+// interval to w and counts the errors it suppresses in between.
+type ratelimitedWriter struct {
+	w     io.Writer
+	every time.Duration
+
+	mu         sync.Mutex
+	last       time.Time
+	suppressed int
+}
+
+func (rw *ratelimitedWriter) HandleError(h Handler, op string, err error) {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+
+	now := time.Now()
+	if !rw.last.IsZero() && now.Sub(rw.last) < rw.every {
+		rw.suppressed++
+		return
+	}
+
+	if rw.suppressed > 0 {
+		fmt.Fprintf(rw.w, "trace: %T.%s: %v (%d more errors suppressed)\n", h, op, err, rw.suppressed)
+	} else {
+		fmt.Fprintf(rw.w, "trace: %T.%s: %v\n", h, op, err)
+	}
+	rw.last = now
+	rw.suppressed = 0
+}
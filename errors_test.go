@@ -0,0 +1,64 @@
+package trace_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/dzrw/trace"
+	"github.com/stretchr/testify/require"
+)
+
+var SiteTestErrors = trace.Site()
+
+type failingRecorder struct {
+	metricsRecorder
+}
+
+func (h *failingRecorder) Count(trace.Tracepoint, int64) error {
+	return errors.New("sink unavailable")
+}
+
+func TestReportError(t *testing.T) {
+	var ops []string
+	trace.SetErrorHandler(trace.ErrorHandlerFunc(func(h trace.Handler, op string, err error) {
+		ops = append(ops, op+": "+err.Error())
+	}))
+	defer trace.SetErrorHandler(nil)
+
+	h := &failingRecorder{metricsRecorder: *newMetricsRecorder()}
+	SiteTestErrors.Install(h)
+	defer SiteTestErrors.Uninstall()
+
+	SiteTestErrors.Count(1)
+	SiteTestErrors.Count(1)
+	SiteTestErrors.Gauge(1)
+
+	require.Equal(t, []string{"Count: sink unavailable", "Count: sink unavailable"}, ops)
+	require.Equal(t, uint64(2), trace.SiteErrors(SiteTestErrors))
+
+	SiteTestErrors.Install(newMetricsRecorder())
+	require.Equal(t, uint64(0), trace.SiteErrors(SiteTestErrors))
+}
+
+var SiteTestErrorsFlush = trace.Site()
+
+func TestReportErrorFlush(t *testing.T) {
+	trace.SetErrorHandler(trace.ErrorHandlerFunc(func(trace.Handler, string, error) {}))
+	defer trace.SetErrorHandler(nil)
+
+	h := trace.NewAggregator(&failingRecorder{metricsRecorder: *newMetricsRecorder()}, time.Millisecond)
+	sb := trace.NewSwitchboard(h)
+	SiteTestErrorsFlush.Install(sb)
+	defer SiteTestErrorsFlush.Uninstall()
+
+	SiteTestErrorsFlush.Count(1)
+	require.Eventually(t, func() bool {
+		return trace.SiteErrors(SiteTestErrorsFlush) > 0
+	}, time.Second, time.Millisecond)
+
+	// The counts of a graph are dropped once it is swapped out and closed.
+	require.NoError(t, sb.Swap(context.Background(), newMetricsRecorder()))
+	require.Equal(t, uint64(0), trace.SiteErrors(SiteTestErrorsFlush))
+}
@@ -0,0 +1,236 @@
+package trace
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultFlightRecorderSize is the number of events kept by a FlightRecorder
+// when its options do not set Size.
+const DefaultFlightRecorderSize = 4096
+
+// FlightRecorderOptions configure a FlightRecorder.
+type FlightRecorderOptions struct {
+	// Size is the number of events kept, or DefaultFlightRecorderSize if not
+	// positive.
+	Size int
+
+	// Output receives the automatic dumps, or os.Stderr if nil. Errors
+	// writing to Output are ignored.
+	Output io.Writer
+
+	// Registry identifies the sites in dumps. Sites that it does not
+	// identify are written with their IDs.
+	Registry Registry
+
+	// SourceInfo records the call site of every event, which is costly.
+	SourceInfo bool
+}
+
+/*
+FlightRecorder keeps the last events logged at every level, including
+NoiseLevel, in a ring that is only written out when something goes wrong:
+
+  - when an event is logged at ErrorLevel or AssertionViolatedLevel, the
+    events recorded since the last automatic dump are written to Output;
+  - on SIGUSR1, where the platform has it, and in DumpOnPanic, the whole ring
+    is written to Output;
+  - DumpFlightRecorder writes the whole ring to any io.Writer.
+
+Recording an event does not take locks. Events are recorded regardless of the
+levels of the handlers, the default level and the sites enabled by
+SetSiteEnabled, but sites without a Handler originate no traces, so they have
+no events to record. The ring holds copies of the attrs of the events, and
+identifies their traces without keeping them alive.
+*/
+type FlightRecorder struct {
+	opts  FlightRecorderOptions
+	slots []atomic.Pointer[flightevent]
+	next  uint64 // the sequence number of the next event
+
+	mu     sync.Mutex // serializes dumps
+	h      *TextHandler
+	w      io.Writer
+	dumped uint64 // the sequence number after the last automatic dump
+
+	stop chan struct{}
+	done chan struct{}
+	once sync.Once
+}
+
+type flightevent struct {
+	seq uint64
+	r   Record
+}
+
+var flightRecorder atomic.Pointer[FlightRecorder]
+
+// StartFlightRecorder starts recording events, replacing any running
+// FlightRecorder.
+func StartFlightRecorder(opts FlightRecorderOptions) *FlightRecorder {
+	if opts.Size <= 0 {
+		opts.Size = DefaultFlightRecorderSize
+	}
+	if opts.Output == nil {
+		opts.Output = os.Stderr
+	}
+
+	fr := &FlightRecorder{
+		opts:  opts,
+		slots: make([]atomic.Pointer[flightevent], opts.Size),
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	fr.h = NewTextHandler(writerFunc(fr.write), NoiseLevel, opts.SourceInfo, false, opts.Registry)
+
+	// Signals are registered before returning, so that none are missed.
+	var sig chan os.Signal
+	if len(dumpSignals) > 0 {
+		sig = make(chan os.Signal, 1)
+		signal.Notify(sig, dumpSignals...)
+	}
+	go fr.run(sig)
+
+	if old := flightRecorder.Swap(fr); old != nil {
+		old.Close()
+	}
+	return fr
+}
+
+func (fr *FlightRecorder) run(sig chan os.Signal) {
+	defer close(fr.done)
+	if sig != nil {
+		defer signal.Stop(sig)
+	}
+
+	for {
+		select {
+		case <-sig:
+			fr.Dump(fr.opts.Output)
+		case <-fr.stop:
+			return
+		}
+	}
+}
+
+// record adds r to the ring, and dumps the ring if r is an error.
+func (fr *FlightRecorder) record(r Record) {
+	if !fr.opts.SourceInfo {
+		r.PC = 0
+	}
+	seq := atomic.AddUint64(&fr.next, 1) - 1
+	fr.slots[seq%uint64(len(fr.slots))].Store(&flightevent{seq: seq, r: r})
+
+	if r.Level == ErrorLevel || r.Level == AssertionViolatedLevel {
+		fr.mu.Lock()
+		defer fr.mu.Unlock()
+		if seq >= fr.dumped {
+			fr.dump(fr.opts.Output, fr.dumped, seq+1)
+			fr.dumped = seq + 1
+		}
+	}
+}
+
+// Dump writes the events in the ring to w, oldest first, in the format of a
+// TextHandler preceded by their time and level.
+func (fr *FlightRecorder) Dump(w io.Writer) error {
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+	return fr.dump(w, 0, atomic.LoadUint64(&fr.next))
+}
+
+// dump writes the events from sequence number from up to end to w.
+func (fr *FlightRecorder) dump(w io.Writer, from, end uint64) error {
+	if n := uint64(len(fr.slots)); end-from > n {
+		from = end - n
+	}
+
+	fr.w = w
+	defer func() { fr.w = nil }()
+
+	if _, err := fmt.Fprintf(w, "trace: flight recorder: events %d to %d\n", from, end); err != nil {
+		return err
+	}
+	for seq := from; seq < end; seq++ {
+		ev := fr.slots[seq%uint64(len(fr.slots))].Load()
+		if ev == nil || ev.seq != seq {
+			// Not yet written, or already overwritten by a newer event.
+			continue
+		}
+		if err := fr.write1(ev.r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// write1 writes r as a single line: its time and level, followed by r as
+// formatted by a TextHandler. Sites that the Registry does not identify are
+// identified by their ID.
+func (fr *FlightRecorder) write1(r Record) error {
+	site, ok := fr.h.reg.IdentifierFor(r.Site)
+	if !ok && r.Site != nil {
+		site = r.Site.ID().String()
+	}
+
+	sb := strings.Builder{}
+	sb.WriteByte(' ')
+	sb.WriteString(r.Time.Format(RFC3339Milli))
+	sb.WriteByte(' ')
+	sb.WriteString(r.Level.String())
+	fr.h.format(&sb, site, r)
+	return fr.h.finish(&sb)
+}
+
+// write passes the output of the FlightRecorder's TextHandler to the
+// io.Writer of the dump in progress.
+func (fr *FlightRecorder) write(p []byte) (int, error) {
+	return fr.w.Write(p)
+}
+
+// Close stops recording events.
+func (fr *FlightRecorder) Close() error {
+	flightRecorder.CompareAndSwap(fr, nil)
+	fr.once.Do(func() { close(fr.stop) })
+	<-fr.done
+	return nil
+}
+
+// DumpFlightRecorder writes the events of the running FlightRecorder to w. It
+// does nothing if no FlightRecorder is running.
+func DumpFlightRecorder(w io.Writer) error {
+	if fr := flightRecorder.Load(); fr != nil {
+		return fr.Dump(w)
+	}
+	return nil
+}
+
+/*
+DumpOnPanic writes the events of the running FlightRecorder to its Output if
+the goroutine is panicking, then continues panicking. It must be deferred
+directly:
+
+	func main() {
+		defer trace.DumpOnPanic()
+		...
+	}
+*/
+func DumpOnPanic() {
+	if v := recover(); v != nil {
+		if fr := flightRecorder.Load(); fr != nil {
+			fr.Dump(fr.opts.Output)
+		}
+		panic(v)
+	}
+}
+
+type writerFunc func([]byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) {
+	return f(p)
+}
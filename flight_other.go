@@ -0,0 +1,9 @@
+//go:build !unix
+
+package trace
+
+import "os"
+
+// dumpSignals are the signals on which a FlightRecorder dumps its events.
+// Platforms without SIGUSR1 have none.
+var dumpSignals []os.Signal
@@ -0,0 +1,146 @@
+package trace_test
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/dzrw/trace"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	SiteTestFlight        = trace.Site()
+	SiteTestFlightUnnamed = trace.Site()
+)
+
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestFlightRecorder(t *testing.T) {
+	reg := trace.NewRegistry()
+	reg.Define(SiteTestFlight, "flight")
+	SiteTestFlight.Install(trace.NewTextHandler(io.Discard, trace.ErrorLevel, false, false, nil))
+	defer SiteTestFlight.Uninstall()
+
+	out := &syncBuffer{}
+	fr := trace.StartFlightRecorder(trace.FlightRecorderOptions{Size: 4, Output: out, Registry: reg})
+	defer fr.Close()
+
+	tr := SiteTestFlight.Trace()
+	for i := 0; i < 6; i++ {
+		tr.Log(trace.NoiseLevel, trace.Int("i", i))
+	}
+	require.Empty(t, out.String(), "events are only written when something goes wrong")
+
+	buf := bytes.Buffer{}
+	require.NoError(t, trace.DumpFlightRecorder(&buf))
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 5)
+	require.Equal(t, "trace: flight recorder: events 2 to 6", lines[0])
+	require.Contains(t, lines[1], fmt.Sprintf(" %s site=flight trace=%d i=2", trace.NoiseLevel, tr.ID()))
+	require.Contains(t, lines[4], " i=5")
+
+	tr.Error("failed")
+	lines = strings.Split(strings.TrimSpace(out.String()), "\n")
+	require.Len(t, lines, 5)
+	require.Equal(t, "trace: flight recorder: events 3 to 7", lines[0])
+	require.Contains(t, lines[4], "event=failed")
+
+	tr.Debug("again")
+	tr.Error("failed again")
+	lines = strings.Split(strings.TrimSpace(out.String()), "\n")
+	require.Len(t, lines, 8, "automatic dumps do not repeat events")
+	require.Equal(t, "trace: flight recorder: events 7 to 9", lines[5])
+
+	require.NoError(t, syscall.Kill(syscall.Getpid(), syscall.SIGUSR1))
+	require.Eventually(t, func() bool {
+		return strings.Count(out.String(), "trace: flight recorder") == 3
+	}, time.Second, time.Millisecond)
+
+	func() {
+		defer func() { require.Equal(t, "boom", recover()) }()
+		defer trace.DumpOnPanic()
+		panic("boom")
+	}()
+	require.Equal(t, 4, strings.Count(out.String(), "trace: flight recorder"))
+
+	fr.Close()
+	buf.Reset()
+	require.NoError(t, trace.DumpFlightRecorder(&buf))
+	require.Empty(t, buf.String())
+}
+
+func TestFlightRecorderUnknownSite(t *testing.T) {
+	SiteTestFlightUnnamed.Install(trace.NewTextHandler(io.Discard, trace.ErrorLevel, false, false, nil))
+	defer SiteTestFlightUnnamed.Uninstall()
+
+	fr := trace.StartFlightRecorder(trace.FlightRecorderOptions{Output: io.Discard})
+	defer fr.Close()
+
+	tr := SiteTestFlightUnnamed.Trace()
+	tr.Info("hello")
+
+	// A site without an identifier is written with its ID, on one line.
+	buf := bytes.Buffer{}
+	require.NoError(t, fr.Dump(&buf))
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 2)
+	require.Regexp(t, fmt.Sprintf(`^\S+ INFO site=%s trace=%d event=hello$`, SiteTestFlightUnnamed.ID(), tr.ID()), lines[1])
+}
+
+func TestFlightRecorderReleasesTraces(t *testing.T) {
+	SiteTestFlightUnnamed.Install(trace.NewTextHandler(io.Discard, trace.ErrorLevel, false, false, nil))
+	defer SiteTestFlightUnnamed.Uninstall()
+
+	fr := trace.StartFlightRecorder(trace.FlightRecorderOptions{Output: io.Discard})
+	defer fr.Close()
+
+	var collected atomic.Int32
+	d := trace.StartLeakDetector(trace.LeakOptions{
+		Report: func(r trace.LeakReport) {
+			if r.Kind == trace.LeakCollected {
+				collected.Add(1)
+			}
+		},
+	})
+	defer d.Close()
+
+	attrs := []trace.Attr{trace.String("key", "before")}
+	func() {
+		SiteTestFlightUnnamed.Trace(trace.String("user", "u1")).Info("hello", attrs...)
+	}()
+	attrs[0] = trace.String("key", "after")
+
+	// The ring keeps neither the trace, which the LeakDetector sees
+	// collected, nor the caller's attrs.
+	require.Eventually(t, func() bool {
+		runtime.GC()
+		return collected.Load() == 1
+	}, time.Second, time.Millisecond)
+
+	buf := bytes.Buffer{}
+	require.NoError(t, fr.Dump(&buf))
+	require.Contains(t, buf.String(), "user=u1 key=before event=hello\n")
+}
@@ -0,0 +1,11 @@
+//go:build unix
+
+package trace
+
+import (
+	"os"
+	"syscall"
+)
+
+// dumpSignals are the signals on which a FlightRecorder dumps its events.
+var dumpSignals = []os.Signal{syscall.SIGUSR1}
@@ -0,0 +1,269 @@
+package graphite
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dzrw/trace"
+)
+
+var _ = trace.Handler(&GraphiteHandler{})
+var _ = trace.AggregateHandler(&GraphiteHandler{})
+var _ = trace.Flusher(&GraphiteHandler{})
+var _ = trace.Closer(&GraphiteHandler{})
+
+// MaxPending is the number of lines a GraphiteHandler holds while the server
+// is unreachable. Beyond it, the oldest lines are dropped.
+const MaxPending = 10000
+
+// GraphiteHandler is a Handler that writes metrics to a Graphite server in the
+// plaintext protocol, one "path value timestamp" line per metric. Lines are
+// sent by a background goroutine once batch lines are pending, and on Flush.
+// It is intended to sit behind a trace.Aggregator, but also accepts samples
+// directly. It does not accept events.
+type GraphiteHandler struct {
+	addr    string
+	prefix  string
+	batch   int
+	timeout time.Duration
+	reg     trace.Registry
+
+	mu      sync.Mutex // guards pending
+	pending []string
+
+	send sync.Mutex // serializes flushes and guards conn
+	conn net.Conn
+
+	kick chan struct{} // signals a full batch to run
+	stop chan struct{}
+	done chan struct{}
+	once sync.Once
+}
+
+// New creates a GraphiteHandler that writes to the Graphite server at addr
+// over TCP. Each metric path is the tracepoint's identifier in reg, prefixed
+// with prefix if it is not empty. If batch is not positive, lines are only
+// sent on Flush.
+func New(addr, prefix string, batch int, reg trace.Registry) *GraphiteHandler {
+	if reg == nil {
+		reg = trace.NewRegistry()
+	}
+	h := &GraphiteHandler{
+		addr:    addr,
+		prefix:  strings.TrimSuffix(prefix, "."),
+		batch:   batch,
+		timeout: 5 * time.Second,
+		reg:     reg,
+		kick:    make(chan struct{}, 1),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	if batch > 0 {
+		go h.run()
+	} else {
+		close(h.done)
+	}
+	return h
+}
+
+// run sends full batches, so that the goroutines that record metrics never
+// wait for the server.
+func (h *GraphiteHandler) run() {
+	defer close(h.done)
+	for {
+		select {
+		case <-h.kick:
+			trace.ReportError(h, "Flush", h.Flush())
+		case <-h.stop:
+			return
+		}
+	}
+}
+
+func (h *GraphiteHandler) Flags() trace.HandlerFlags {
+	return 0
+}
+
+func (h *GraphiteHandler) Enabled(l trace.Level) bool {
+	return false
+}
+
+func (h *GraphiteHandler) TraceCreated(tr trace.Trace, attrs []trace.Attr) {}
+
+func (h *GraphiteHandler) TraceFinished(tr trace.Trace, attrs []trace.Attr) {}
+
+func (h *GraphiteHandler) Log(tr trace.Trace, l trace.Level, attrs ...[]trace.Attr) error {
+	return nil
+}
+
+func (h *GraphiteHandler) Count(tp trace.Tracepoint, delta int64) error {
+	return h.write(tp, time.Now(), field{"count", strconv.FormatInt(delta, 10)})
+}
+
+func (h *GraphiteHandler) Gauge(tp trace.Tracepoint, value int64) error {
+	return h.write(tp, time.Now(), field{"gauge", strconv.FormatInt(value, 10)})
+}
+
+func (h *GraphiteHandler) Duration(tp trace.Tracepoint, d time.Duration) error {
+	return h.write(tp, time.Now(), field{"duration", millis(d)})
+}
+
+func (h *GraphiteHandler) Histogram(tp trace.Tracepoint, sample int64) error {
+	return h.write(tp, time.Now(), field{"histogram", strconv.FormatInt(sample, 10)})
+}
+
+// Aggregate writes the metrics accumulated by a trace.Aggregator and sends
+// them immediately. Durations are written in milliseconds.
+func (h *GraphiteHandler) Aggregate(arr []trace.Metrics) error {
+	now := time.Now()
+	for _, m := range arr {
+		var fields []field
+		if m.Count != 0 {
+			fields = append(fields, field{"count", strconv.FormatInt(m.Count, 10)})
+		}
+		if m.Errors != 0 {
+			fields = append(fields, field{"errors", strconv.FormatInt(m.Errors, 10)})
+		}
+		if m.Gauge.N > 0 {
+			fields = append(fields,
+				field{"gauge", strconv.FormatInt(m.Gauge.Last, 10)},
+				field{"gauge.min", strconv.FormatInt(m.Gauge.Min, 10)},
+				field{"gauge.max", strconv.FormatInt(m.Gauge.Max, 10)})
+		}
+		if m.Duration.N > 0 {
+			fields = append(fields,
+				field{"duration.count", strconv.FormatInt(m.Duration.N, 10)},
+				field{"duration.mean", millis(m.Duration.Mean())},
+				field{"duration.min", millis(m.Duration.Min)},
+				field{"duration.max", millis(m.Duration.Max)})
+		}
+		h.write(m.Site, now, fields...)
+	}
+	return h.Flush()
+}
+
+type field struct {
+	name  string
+	value string
+}
+
+func (h *GraphiteHandler) write(tp trace.Tracepoint, ts time.Time, fields ...field) error {
+	id, ok := h.reg.IdentifierFor(tp)
+	if !ok || len(fields) == 0 {
+		return nil
+	}
+
+	path := sanitize(id)
+	if h.prefix != "" {
+		path = h.prefix + "." + path
+	}
+	sec := strconv.FormatInt(ts.Unix(), 10)
+
+	h.mu.Lock()
+	for _, f := range fields {
+		h.pending = append(h.pending, path+"."+f.name+" "+f.value+" "+sec+"\n")
+	}
+	if over := len(h.pending) - MaxPending; over > 0 {
+		h.pending = h.pending[over:]
+	}
+	full := h.batch > 0 && len(h.pending) >= h.batch
+	h.mu.Unlock()
+
+	if full {
+		select {
+		case h.kick <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// Flush sends the pending lines. If the connection has failed, it reconnects
+// and retries once; if that also fails, the lines are kept for the next
+// flush. Metrics recorded during the flush are not held up by it.
+func (h *GraphiteHandler) Flush() error {
+	h.send.Lock()
+	defer h.send.Unlock()
+
+	h.mu.Lock()
+	lines := h.pending
+	h.pending = nil
+	h.mu.Unlock()
+
+	if err := h.flush(lines); err != nil {
+		h.mu.Lock()
+		h.pending = append(lines, h.pending...)
+		if over := len(h.pending) - MaxPending; over > 0 {
+			h.pending = h.pending[over:]
+		}
+		h.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+func (h *GraphiteHandler) flush(lines []string) error {
+	if len(lines) == 0 {
+		return nil
+	}
+
+	buf := []byte(strings.Join(lines, ""))
+
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		if h.conn == nil {
+			if h.conn, err = net.DialTimeout("tcp", h.addr, h.timeout); err != nil {
+				h.conn = nil
+				continue
+			}
+		}
+		h.conn.SetWriteDeadline(time.Now().Add(h.timeout))
+		if _, err = h.conn.Write(buf); err != nil {
+			h.conn.Close()
+			h.conn = nil
+			continue
+		}
+		return nil
+	}
+	return err
+}
+
+// Close stops the background goroutine, sends the pending lines and closes
+// the connection.
+func (h *GraphiteHandler) Close() error {
+	h.once.Do(func() { close(h.stop) })
+	<-h.done
+
+	err := h.Flush()
+	h.send.Lock()
+	defer h.send.Unlock()
+	if h.conn != nil {
+		if cerr := h.conn.Close(); err == nil {
+			err = cerr
+		}
+		h.conn = nil
+	}
+	return err
+}
+
+func millis(d time.Duration) string {
+	return strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', -1, 64)
+}
+
+// sanitize replaces the characters that are not safe in a Graphite path with
+// underscores. Dots are kept, since they separate the path's nodes.
+func sanitize(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		case r == '.', r == '_', r == '-':
+			return r
+		default:
+			return '_'
+		}
+	}, s)
+}
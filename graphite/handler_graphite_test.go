@@ -0,0 +1,96 @@
+package graphite_test
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dzrw/trace"
+	"github.com/dzrw/trace/graphite"
+	"github.com/stretchr/testify/require"
+)
+
+var SiteTestHandler = trace.Site()
+
+func TestGraphiteHandler(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+
+	lines := make(chan string, 16)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		sc := bufio.NewScanner(conn)
+		for sc.Scan() {
+			lines <- sc.Text()
+		}
+	}()
+
+	reg := trace.NewRegistry()
+	reg.Define(SiteTestHandler, "graphite_test.Site Test")
+
+	h := graphite.New(ln.Addr().String(), "app.", 0, reg)
+	defer h.Close()
+
+	agg := trace.NewAggregator(h, 0)
+	SiteTestHandler.Install(agg)
+	defer SiteTestHandler.Uninstall()
+
+	SiteTestHandler.Count(2)
+	SiteTestHandler.Count(3)
+	require.NoError(t, agg.Flush())
+
+	select {
+	case line := <-lines:
+		fields := strings.Fields(line)
+		require.Len(t, fields, 3)
+		require.Equal(t, "app.graphite_test.Site_Test.count", fields[0])
+		require.Equal(t, "5", fields[1])
+	case <-time.After(time.Second):
+		require.Fail(t, "timed out")
+	}
+}
+
+func TestGraphiteHandlerBatch(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+
+	lines := make(chan string, 16)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		sc := bufio.NewScanner(conn)
+		for sc.Scan() {
+			lines <- sc.Text()
+		}
+	}()
+
+	reg := trace.NewRegistry()
+	reg.Define(SiteTestHandler, "batch")
+
+	h := graphite.New(ln.Addr().String(), "", 2, reg)
+	defer h.Close()
+
+	require.NoError(t, h.Count(SiteTestHandler, 1))
+	require.NoError(t, h.Gauge(SiteTestHandler, 7))
+
+	// The batch is full, so it is sent without a Flush.
+	for _, want := range []string{"batch.count 1", "batch.gauge 7"} {
+		select {
+		case line := <-lines:
+			require.True(t, strings.HasPrefix(line, want+" "), line)
+		case <-time.After(time.Second):
+			require.Fail(t, "timed out")
+		}
+	}
+}
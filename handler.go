@@ -5,10 +5,26 @@ import "time"
 type HandlerFlags int16
 
 const (
-	FlagSourceInfo  HandlerFlags = 1 << iota
-	FlagGoroutineID HandlerFlags = 1 << iota
+	FlagSourceInfo     HandlerFlags = 1 << iota
+	FlagGoroutineID    HandlerFlags = 1 << iota
+	FlagPreformatAttrs HandlerFlags = 1 << iota // see Preformat
+	FlagStackTrace     HandlerFlags = 1 << iota // see SetStackTraceLevel
 )
 
+// A LinkHandler is a Handler that is notified when a link is added to a trace
+// after the trace was created. Links given to Tracepoint.Trace are passed to
+// TraceCreated as attrs of LinkKind instead.
+type LinkHandler interface {
+	TraceLinked(Trace, Link)
+}
+
+// A HandlerGroup is a Handler that passes traces and events to other
+// handlers. The errors reported for the handlers of a group are counted
+// against the group by SiteErrors.
+type HandlerGroup interface {
+	Handlers() []Handler
+}
+
 // A Handler processes traces and associated event logs.
 type Handler interface {
 	// Flags returns the options set on this handler.
@@ -29,9 +45,17 @@ type Handler interface {
 	// Histogram records a sample.
 	Histogram(Tracepoint, int64) error
 
-	// Log an event.
+	// Log an event. Unless FlagPreformatAttrs is set, the first slice of
+	// attrs holds the attrs of the trace. Tracepoints deliver events through
+	// Handle instead if the Handler also implements RecordHandler.
 	Log(Trace, Level, ...[]Attr) error
 
+	// TraceCreated is called when a trace starts. The attrs hold the attrs
+	// of the trace and any source information.
 	TraceCreated(Trace, []Attr)
+
+	// TraceFinished is called when a trace is closed. The attrs are those
+	// passed to Close; the attrs and outcome of the trace are available from
+	// Trace.Attrs and Trace.Status.
 	TraceFinished(Trace, []Attr)
 }
@@ -0,0 +1,237 @@
+package trace
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+var _ = Handler(&Aggregator{})
+var _ = RecordHandler(&Aggregator{})
+var _ = ContextHandler(&Aggregator{})
+var _ = LinkHandler(&Aggregator{})
+var _ = Flusher(&Aggregator{})
+var _ = Closer(&Aggregator{})
+var _ = HandlerGroup(&Aggregator{})
+
+// Aggregator is a Handler that accumulates counters, gauges and durations in
+// memory and periodically flushes them to a downstream Handler. Events and
+// traces, including their links, are passed through to the downstream Handler
+// unchanged.
+type Aggregator struct {
+	next Handler
+	ch   ContextHandler
+	rh   RecordHandler
+
+	mu     sync.Mutex
+	total  map[Tracepoint]*Metrics
+	window map[Tracepoint]*Metrics
+
+	stop chan struct{}
+	done chan struct{}
+	once sync.Once
+}
+
+// NewAggregator creates an Aggregator that flushes to next every interval. If
+// interval is not positive, metrics are only flushed by calls to Flush.
+func NewAggregator(next Handler, interval time.Duration) *Aggregator {
+	h := &Aggregator{
+		next:   next,
+		ch:     contextHandler(next),
+		rh:     AdaptHandler(next),
+		total:  make(map[Tracepoint]*Metrics),
+		window: make(map[Tracepoint]*Metrics),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	if interval > 0 {
+		go h.run(interval)
+	} else {
+		close(h.done)
+	}
+	return h
+}
+
+func (h *Aggregator) run(interval time.Duration) {
+	defer close(h.done)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			ReportError(h, "Flush", h.Flush())
+		case <-h.stop:
+			return
+		}
+	}
+}
+
+func (h *Aggregator) Flags() HandlerFlags {
+	return h.next.Flags()
+}
+
+func (h *Aggregator) Enabled(l Level) bool {
+	return h.next.Enabled(l)
+}
+
+func (h *Aggregator) TraceCreated(tr Trace, attrs []Attr) {
+	h.next.TraceCreated(tr, attrs)
+}
+
+func (h *Aggregator) TraceFinished(tr Trace, attrs []Attr) {
+	h.countErrors(tr)
+	h.next.TraceFinished(tr, attrs)
+}
+
+func (h *Aggregator) TraceLinked(tr Trace, l Link) {
+	if lh, ok := h.next.(LinkHandler); ok {
+		lh.TraceLinked(tr, l)
+	}
+}
+
+func (h *Aggregator) EnabledContext(ctx context.Context, l Level) bool {
+	return h.ch.EnabledContext(ctx, l)
+}
+
+func (h *Aggregator) HandleContext(ctx context.Context, r Record) error {
+	return h.ch.HandleContext(ctx, r)
+}
+
+func (h *Aggregator) TraceCreatedContext(ctx context.Context, tr Trace, attrs []Attr) {
+	h.ch.TraceCreatedContext(ctx, tr, attrs)
+}
+
+func (h *Aggregator) TraceFinishedContext(ctx context.Context, tr Trace, attrs []Attr) {
+	h.countErrors(tr)
+	h.ch.TraceFinishedContext(ctx, tr, attrs)
+}
+
+// Handlers returns the downstream Handler.
+func (h *Aggregator) Handlers() []Handler {
+	return []Handler{h.next}
+}
+
+func (h *Aggregator) countErrors(tr Trace) {
+	if tr.Status().Failed() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		h.metrics(h.total, tr.Site()).Errors++
+		h.metrics(h.window, tr.Site()).Errors++
+	}
+}
+
+func (h *Aggregator) Count(tp Tracepoint, delta int64) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.metrics(h.total, tp).Count += delta
+	h.metrics(h.window, tp).Count += delta
+	return nil
+}
+
+func (h *Aggregator) Gauge(tp Tracepoint, value int64) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.metrics(h.total, tp).Gauge.observe(value)
+	h.metrics(h.window, tp).Gauge.observe(value)
+	return nil
+}
+
+func (h *Aggregator) Duration(tp Tracepoint, d time.Duration) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.metrics(h.total, tp).Duration.observe(d)
+	h.metrics(h.window, tp).Duration.observe(d)
+	return nil
+}
+
+func (h *Aggregator) Histogram(tp Tracepoint, sample int64) error {
+	return h.next.Histogram(tp, sample)
+}
+
+func (h *Aggregator) Log(tr Trace, l Level, attrs ...[]Attr) error {
+	return h.next.Log(tr, l, attrs...)
+}
+
+func (h *Aggregator) Handle(r Record) error {
+	return h.rh.Handle(r)
+}
+
+func (h *Aggregator) metrics(m map[Tracepoint]*Metrics, tp Tracepoint) *Metrics {
+	if v, ok := m[tp]; ok {
+		return v
+	}
+	v := &Metrics{Site: tp}
+	m[tp] = v
+	return v
+}
+
+// Snapshot returns the metrics accumulated by each tracepoint since the
+// Aggregator was created.
+func (h *Aggregator) Snapshot() []Metrics {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return collect(h.total)
+}
+
+// Flush sends the metrics accumulated since the previous flush to the
+// downstream Handler. If the downstream Handler implements AggregateHandler,
+// it receives the metrics in a single call; otherwise, each site's counter
+// delta, last gauge value and mean duration are sent through Count, Gauge and
+// Duration, and error counts are not sent.
+func (h *Aggregator) Flush() error {
+	h.mu.Lock()
+	window := collect(h.window)
+	h.window = make(map[Tracepoint]*Metrics)
+	h.mu.Unlock()
+
+	if len(window) == 0 {
+		return nil
+	}
+
+	if ah, ok := h.next.(AggregateHandler); ok {
+		return ah.Aggregate(window)
+	}
+
+	var first error
+	keep := func(err error) {
+		if first == nil {
+			first = err
+		}
+	}
+	for _, m := range window {
+		if m.Count != 0 {
+			keep(h.next.Count(m.Site, m.Count))
+		}
+		if m.Gauge.N > 0 {
+			keep(h.next.Gauge(m.Site, m.Gauge.Last))
+		}
+		if m.Duration.N > 0 {
+			keep(h.next.Duration(m.Site, m.Duration.Mean()))
+		}
+	}
+	return first
+}
+
+// Close stops the periodic flush, flushes any remaining metrics and closes
+// the downstream Handler if it is a Closer.
+func (h *Aggregator) Close() error {
+	h.once.Do(func() { close(h.stop) })
+	<-h.done
+	err := h.Flush()
+	if c, ok := h.next.(Closer); ok {
+		if cerr := c.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+func collect(m map[Tracepoint]*Metrics) []Metrics {
+	arr := make([]Metrics, 0, len(m))
+	for _, v := range m {
+		arr = append(arr, *v)
+	}
+	return arr
+}
@@ -0,0 +1,105 @@
+package trace_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dzrw/trace"
+	"github.com/stretchr/testify/require"
+)
+
+var SiteTestAggregator = trace.Site()
+var SiteTestAggregatorLink = trace.Site()
+
+type metricsRecorder struct {
+	counts    map[trace.Tracepoint]int64
+	gauges    map[trace.Tracepoint]int64
+	durations map[trace.Tracepoint]time.Duration
+}
+
+func newMetricsRecorder() *metricsRecorder {
+	return &metricsRecorder{
+		counts:    make(map[trace.Tracepoint]int64),
+		gauges:    make(map[trace.Tracepoint]int64),
+		durations: make(map[trace.Tracepoint]time.Duration),
+	}
+}
+
+func (h *metricsRecorder) Flags() trace.HandlerFlags               { return 0 }
+func (h *metricsRecorder) Enabled(trace.Level) bool                { return true }
+func (h *metricsRecorder) TraceCreated(trace.Trace, []trace.Attr)  {}
+func (h *metricsRecorder) TraceFinished(trace.Trace, []trace.Attr) {}
+func (h *metricsRecorder) Histogram(trace.Tracepoint, int64) error { return nil }
+func (h *metricsRecorder) Log(trace.Trace, trace.Level, ...[]trace.Attr) error {
+	return nil
+}
+
+func (h *metricsRecorder) Count(tp trace.Tracepoint, delta int64) error {
+	h.counts[tp] += delta
+	return nil
+}
+
+func (h *metricsRecorder) Gauge(tp trace.Tracepoint, value int64) error {
+	h.gauges[tp] = value
+	return nil
+}
+
+func (h *metricsRecorder) Duration(tp trace.Tracepoint, d time.Duration) error {
+	h.durations[tp] = d
+	return nil
+}
+
+func TestAggregator(t *testing.T) {
+	next := newMetricsRecorder()
+	h := trace.NewAggregator(next, 0)
+	SiteTestAggregator.Install(h)
+	defer SiteTestAggregator.Uninstall()
+
+	SiteTestAggregator.Count(1)
+	SiteTestAggregator.Count(2)
+	SiteTestAggregator.Gauge(5)
+	SiteTestAggregator.Gauge(3)
+	SiteTestAggregator.Duration(time.Second)
+	SiteTestAggregator.Duration(3 * time.Second)
+
+	snap := h.Snapshot()
+	require.Len(t, snap, 1)
+	require.Equal(t, int64(3), snap[0].Count)
+	require.Equal(t, trace.GaugeSummary{N: 2, Last: 3, Min: 3, Max: 5}, snap[0].Gauge)
+	require.Equal(t, 2*time.Second, snap[0].Duration.Mean())
+	require.Empty(t, next.counts)
+
+	require.NoError(t, h.Flush())
+	require.Equal(t, int64(3), next.counts[SiteTestAggregator])
+	require.Equal(t, int64(3), next.gauges[SiteTestAggregator])
+	require.Equal(t, 2*time.Second, next.durations[SiteTestAggregator])
+
+	SiteTestAggregator.Count(4)
+	require.NoError(t, h.Close())
+	require.Equal(t, int64(7), next.counts[SiteTestAggregator])
+	require.Equal(t, int64(7), h.Snapshot()[0].Count)
+}
+
+type linkRecorder struct {
+	*metricsRecorder
+	links []trace.Link
+}
+
+func (h *linkRecorder) TraceLinked(tr trace.Trace, l trace.Link) {
+	h.links = append(h.links, l)
+}
+
+func TestAggregatorLink(t *testing.T) {
+	next := &linkRecorder{metricsRecorder: newMetricsRecorder()}
+	SiteTestAggregatorLink.Install(trace.NewAggregator(next, 0))
+	defer SiteTestAggregatorLink.Uninstall()
+
+	other := SiteTestAggregatorLink.Trace()
+	tr := SiteTestAggregatorLink.Trace()
+	tr.AddLink(trace.RemoteContextOf(other))
+	tr.Close()
+	other.Close()
+
+	require.Len(t, next.links, 1)
+	require.Equal(t, other.ID(), next.links[0].Context.Trace)
+}
@@ -0,0 +1,62 @@
+package trace
+
+import "context"
+
+// A ContextHandler is a Handler that receives the context.Context associated
+// with each trace and event, so that it can read request-scoped values and
+// honour cancellation. A Tracepoint calls the methods of a ContextHandler in
+// preference to their counterparts in Handler and RecordHandler.
+//
+// The context of an event is the one given to the package-level helpers such
+// as Info, or else the one the trace was created with; see
+// Tracepoint.TraceContext.
+type ContextHandler interface {
+	Handler
+
+	// EnabledContext returns whether this handler accepts events at a level.
+	EnabledContext(context.Context, Level) bool
+
+	// HandleContext handles an event; see RecordHandler.Handle.
+	HandleContext(context.Context, Record) error
+
+	// TraceCreatedContext is called when a trace starts.
+	TraceCreatedContext(context.Context, Trace, []Attr)
+
+	// TraceFinishedContext is called when a trace is closed.
+	TraceFinishedContext(context.Context, Trace, []Attr)
+}
+
+// AdaptContextHandler returns h as a ContextHandler. If h is not a
+// ContextHandler, the contexts are ignored.
+func AdaptContextHandler(h Handler) ContextHandler {
+	return contextHandler(h)
+}
+
+// contextHandler returns h as a ContextHandler, adapting it if necessary.
+func contextHandler(h Handler) ContextHandler {
+	if ch, ok := h.(ContextHandler); ok {
+		return ch
+	}
+	return &contextAdapter{h, AdaptHandler(h)}
+}
+
+type contextAdapter struct {
+	Handler
+	rh RecordHandler
+}
+
+func (h *contextAdapter) EnabledContext(_ context.Context, l Level) bool {
+	return h.Enabled(l)
+}
+
+func (h *contextAdapter) HandleContext(_ context.Context, r Record) error {
+	return h.rh.Handle(r)
+}
+
+func (h *contextAdapter) TraceCreatedContext(_ context.Context, tr Trace, attrs []Attr) {
+	h.TraceCreated(tr, attrs)
+}
+
+func (h *contextAdapter) TraceFinishedContext(_ context.Context, tr Trace, attrs []Attr) {
+	h.TraceFinished(tr, attrs)
+}
@@ -0,0 +1,276 @@
+package trace
+
+import (
+	"encoding"
+	"encoding/json"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var _ = Handler(&JSONHandler{})
+var _ = RecordHandler(&JSONHandler{})
+var _ = LinkHandler(&JSONHandler{})
+var _ = Flusher(&JSONHandler{})
+
+// JSONHandler is a Handler that writes to an io.Writer as line-delimited
+// JSON.
+type JSONHandler struct {
+	flags HandlerFlags
+	l     Level
+	reg   Registry
+	mu    sync.Mutex
+	w     io.Writer
+}
+
+// NewJSONHandler creates a JSONHandler that writes to w using the default
+// options.
+func NewJSONHandler(w io.Writer, l Level, includeSourceInfo, includeGoroutineID bool, reg Registry) *JSONHandler {
+	var flags HandlerFlags
+	if includeSourceInfo {
+		flags |= FlagSourceInfo
+	}
+	if includeGoroutineID {
+		flags |= FlagGoroutineID
+	}
+	return NewJSONHandlerWithFlags(w, l, flags, reg)
+}
+
+// NewJSONHandlerWithFlags creates a JSONHandler that writes to w using the
+// options set in flags.
+func NewJSONHandlerWithFlags(w io.Writer, l Level, flags HandlerFlags, reg Registry) *JSONHandler {
+	if reg == nil {
+		reg = NewRegistry()
+	}
+	return &JSONHandler{
+		flags: flags,
+		l:     l,
+		reg:   reg,
+		mu:    sync.Mutex{},
+		w:     w,
+	}
+}
+
+func (h *JSONHandler) Flags() HandlerFlags {
+	return h.flags
+}
+
+func (h *JSONHandler) Enabled(l Level) bool {
+	return l <= h.l
+}
+
+func (h *JSONHandler) TraceCreated(tr Trace, attrs []Attr) {
+	r := NewRecord(time.Now(), DebugLevel, "trace created", 0)
+	r.Site, r.Trace = tr.Site(), tr
+	r.AddAttrs(attrs...)
+	ReportError(h, "TraceCreated", h.handle(r, false))
+}
+
+func (h *JSONHandler) TraceFinished(tr Trace, attrs []Attr) {
+	r := NewRecord(time.Now(), DebugLevel, "trace finished", 0)
+	r.Site, r.Trace = tr.Site(), tr
+	if (h.flags & FlagPreformatAttrs) != FlagPreformatAttrs {
+		r.AddAttrs(tr.Attrs()...)
+	}
+	r.AddAttrs(Duration("elapsed", tr.Elapsed()))
+	r.AddAttrs(tr.Status().Attrs()...)
+	r.AddAttrs(attrs...)
+	ReportError(h, "TraceFinished", h.Handle(r))
+}
+
+func (h *JSONHandler) TraceLinked(tr Trace, l Link) {
+	r := NewRecord(time.Now(), DebugLevel, "trace linked", 0)
+	r.Site, r.Trace = tr.Site(), tr
+	r.AddAttrs(LinkTo(l.Context))
+	r.AddAttrs(l.Attrs...)
+	ReportError(h, "TraceLinked", h.Handle(r))
+}
+
+func (h *JSONHandler) Count(tp Tracepoint, delta int64) error {
+	return h.metric(tp, Int64("count", delta))
+}
+
+func (h *JSONHandler) Gauge(tp Tracepoint, value int64) error {
+	return h.metric(tp, Int64("gauge", value))
+}
+
+func (h *JSONHandler) Duration(tp Tracepoint, d time.Duration) error {
+	return h.metric(tp, Duration("duration", d))
+}
+
+func (h *JSONHandler) Histogram(tp Tracepoint, sample int64) error {
+	return h.metric(tp, Int64("histogram", sample))
+}
+
+func (h *JSONHandler) metric(tp Tracepoint, a Attr) error {
+	if site, ok := h.reg.IdentifierFor(tp); ok {
+		buf := []byte{'{'}
+		buf = appendJSONAttr(buf, String("site", site))
+		buf = appendJSONAttr(buf, a)
+		return h.finish(buf)
+	}
+	return nil
+}
+
+// Log writes its attrs as a JSON object along with the trace's site and ID,
+// the level and the current time.
+func (h *JSONHandler) Log(tr Trace, l Level, attrs ...[]Attr) error {
+	r := NewRecord(time.Now(), l, "", 0)
+	r.Site, r.Trace = tr.Site(), tr
+	for _, arr := range attrs {
+		r.AddAttrs(arr...)
+	}
+	return h.Handle(r)
+}
+
+/*
+Handle writes a Record as a single-line JSON object. The keys "time",
+"level", "site" and "trace" come first, followed by the Record's attrs, the
+message as "event" and, if known, the call site as "source".
+
+If FlagPreformatAttrs is set, the attrs of the trace are formatted once per
+trace and written after the trace's ID.
+
+Bools and numbers are written as JSON values; other values are written as
+strings, formatted like Attr.Format.
+*/
+func (h *JSONHandler) Handle(r Record) error {
+	return h.handle(r, (h.flags&FlagPreformatAttrs) == FlagPreformatAttrs)
+}
+
+func (h *JSONHandler) handle(r Record, pre bool) error {
+	if r.Level == 0 {
+		return nil
+	}
+
+	site, ok := h.reg.IdentifierFor(r.Site)
+	if !ok {
+		return nil
+	}
+
+	buf := []byte{'{'}
+	if !r.Time.IsZero() {
+		buf = appendJSONAttr(buf, String("time", r.Time.Format(RFC3339Milli)))
+	}
+	buf = appendJSONAttr(buf, String("level", r.Level.String()))
+	buf = appendJSONAttr(buf, String("site", site))
+	buf = appendJSONAttr(buf, Uint64("trace", r.Trace.ID()))
+	if pre {
+		buf = append(buf, Preformat(r.Trace, h, formatJSONAttrs)...)
+	}
+	r.Attrs(func(a Attr) bool {
+		buf = appendJSONAttr(buf, a)
+		return true
+	})
+	if r.Message != "" {
+		buf = appendJSONAttr(buf, Event(r.Message))
+	}
+	if r.PC != 0 {
+		buf = appendJSONAttr(buf, Source(r.PC))
+	}
+	return h.finish(buf)
+}
+
+// Flush flushes the io.Writer if it buffers its output, like a bufio.Writer.
+func (h *JSONHandler) Flush() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if f, ok := h.w.(Flusher); ok {
+		return f.Flush()
+	}
+	return nil
+}
+
+func (h *JSONHandler) finish(buf []byte) error {
+	buf = append(buf, '}', '\n')
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := h.w.Write(buf)
+	return err
+}
+
+// formatJSONAttrs formats attrs as JSON object members, each preceded by a
+// comma, so that they can follow the members written by Handle.
+func formatJSONAttrs(attrs []Attr) string {
+	if len(attrs) == 0 {
+		return ""
+	}
+	buf := []byte{'{'}
+	for _, a := range attrs {
+		buf = appendJSONAttr(buf, a)
+	}
+	buf[0] = ','
+	return string(buf)
+}
+
+// appendJSONAttr appends a as a JSON object member, preceded by a comma
+// unless it is the first member.
+func appendJSONAttr(buf []byte, a Attr) []byte {
+	if n := len(buf); n > 0 && buf[n-1] != '{' {
+		buf = append(buf, ',')
+	}
+	buf = appendJSONString(buf, a.Key())
+	buf = append(buf, ':')
+
+	switch a.Kind() {
+	case BoolKind:
+		return strconv.AppendBool(buf, a.Bool())
+	case Int64Kind:
+		return strconv.AppendInt(buf, a.Int64(), 10)
+	case Uint64Kind:
+		return strconv.AppendUint(buf, a.Uint64(), 10)
+	case Float64Kind:
+		if b, err := json.Marshal(a.Float64()); err == nil {
+			return append(buf, b...)
+		}
+	case StackKind:
+		return appendJSONFrames(buf, a.Frames())
+	case SourceKind:
+		return appendJSONFrame(buf, a.Source())
+	case AnyKind:
+		if v := a.Value(); v != nil {
+			if _, ok := v.(encoding.TextMarshaler); !ok {
+				if _, ok := v.(json.Marshaler); ok {
+					if b, err := json.Marshal(v); err == nil {
+						return append(buf, b...)
+					}
+				}
+			}
+		}
+	}
+
+	_, v := a.Format()
+	return appendJSONString(buf, v)
+}
+
+// appendJSONFrames appends a stack trace as an array of objects with the
+// function, file and line of each frame.
+func appendJSONFrames(buf []byte, frames []Frame) []byte {
+	buf = append(buf, '[')
+	for i, f := range frames {
+		if i > 0 {
+			buf = append(buf, ',')
+		}
+		buf = appendJSONFrame(buf, f)
+	}
+	return append(buf, ']')
+}
+
+// appendJSONFrame appends a frame as an object with its function, file and
+// line.
+func appendJSONFrame(buf []byte, f Frame) []byte {
+	buf = append(buf, `{"function":`...)
+	buf = appendJSONString(buf, f.Function)
+	buf = append(buf, `,"file":`...)
+	buf = appendJSONString(buf, f.File)
+	buf = append(buf, `,"line":`...)
+	buf = strconv.AppendInt(buf, int64(f.Line), 10)
+	return append(buf, '}')
+}
+
+func appendJSONString(buf []byte, s string) []byte {
+	b, _ := json.Marshal(s)
+	return append(buf, b...)
+}
@@ -0,0 +1,77 @@
+package trace_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/dzrw/trace"
+	"github.com/stretchr/testify/require"
+)
+
+var SiteTestJSONHandler = trace.Site()
+
+func TestJSONHandler(t *testing.T) {
+	reg := trace.NewRegistry()
+	reg.Define(SiteTestJSONHandler, "trace_test.SiteTestJSONHandler")
+
+	buf := bytes.Buffer{}
+	h := trace.NewJSONHandler(&buf, trace.DebugLevel, true, false, reg)
+	SiteTestJSONHandler.Install(h)
+	defer SiteTestJSONHandler.Uninstall()
+
+	tr := SiteTestJSONHandler.Trace(trace.Bool("test", true))
+	tr.Info("hello, \"world\"", trace.Int("n", 7), trace.Float64("pi", 3.5))
+	tr.Close()
+	SiteTestJSONHandler.Gauge(99)
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	require.Len(t, lines, 4)
+
+	var event map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(lines[1]), &event))
+	require.Equal(t, "INFO", event["level"])
+	require.Equal(t, "trace_test.SiteTestJSONHandler", event["site"])
+	require.Equal(t, true, event["test"])
+	require.Equal(t, float64(7), event["n"])
+	require.Equal(t, 3.5, event["pi"])
+	require.Equal(t, "hello, \"world\"", event["event"])
+	source := event["source"].(map[string]interface{})
+	require.Equal(t, "github.com/dzrw/trace_test.TestJSONHandler", source["function"])
+	require.Contains(t, source["file"], "handler_json_test.go")
+	require.Equal(t, float64(25), source["line"])
+
+	var finished map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(lines[2]), &finished))
+	require.Equal(t, "trace finished", finished["event"])
+	require.Equal(t, "ok", finished["status"])
+
+	require.Equal(t, `{"site":"trace_test.SiteTestJSONHandler","gauge":99}`, lines[3])
+}
+
+var SiteTestJSONHandlerPreformat = trace.Site()
+
+func TestJSONHandlerPreformat(t *testing.T) {
+	reg := trace.NewRegistry()
+	reg.Define(SiteTestJSONHandlerPreformat, "trace_test.SiteTestJSONHandlerPreformat")
+
+	buf := bytes.Buffer{}
+	h := trace.NewJSONHandlerWithFlags(&buf, trace.DebugLevel, trace.FlagPreformatAttrs, reg)
+	SiteTestJSONHandlerPreformat.Install(h)
+	defer SiteTestJSONHandlerPreformat.Uninstall()
+
+	tr := SiteTestJSONHandlerPreformat.Trace(trace.String("user", "u1"))
+	tr.Info("hello")
+	tr.Close()
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	require.Len(t, lines, 3)
+	for _, line := range lines {
+		require.Equal(t, 1, strings.Count(line, `"user":"u1"`), line)
+
+		var event map[string]interface{}
+		require.NoError(t, json.Unmarshal([]byte(line), &event), line)
+		require.Equal(t, "u1", event["user"])
+	}
+}
@@ -0,0 +1,98 @@
+package trace
+
+import "time"
+
+// A RecordHandler is the successor of Handler. Instead of Log, it receives
+// each event as a Record, which carries the time and call site at which the
+// event was captured.
+//
+// A Tracepoint delivers events to an installed Handler through Handle if the
+// Handler also implements RecordHandler. To install a RecordHandler that does
+// not implement Log, wrap it with AdaptRecordHandler.
+type RecordHandler interface {
+	// Flags returns the options set on this handler.
+	Flags() HandlerFlags
+
+	// Enabled returns whether this handler accepts events at a level.
+	Enabled(Level) bool
+
+	// Count records a delta to a counter.
+	Count(Tracepoint, int64) error
+
+	// Gauge records the value of a gauge.
+	Gauge(Tracepoint, int64) error
+
+	// Duration records an elapsed time.
+	Duration(Tracepoint, time.Duration) error
+
+	// Histogram records a sample.
+	Histogram(Tracepoint, int64) error
+
+	// Handle an event. Unless FlagPreformatAttrs is set, the attrs of the
+	// Record begin with the attrs of the trace. The PC of the Record is only
+	// set if FlagSourceInfo is set.
+	Handle(Record) error
+
+	// TraceCreated is called when a trace starts.
+	TraceCreated(Trace, []Attr)
+
+	// TraceFinished is called when a trace is closed.
+	TraceFinished(Trace, []Attr)
+}
+
+// AdaptHandler returns a RecordHandler that delivers events to h. If h is
+// already a RecordHandler, it is returned. Otherwise, each Record is passed
+// to h.Log as the attrs of the trace, followed by the attrs of the event, the
+// message as an Event attr, and the Source of the call site.
+func AdaptHandler(h Handler) RecordHandler {
+	if rh, ok := h.(RecordHandler); ok {
+		return rh
+	}
+	return &handlerAdapter{h}
+}
+
+type handlerAdapter struct {
+	Handler
+}
+
+func (h *handlerAdapter) Handle(r Record) error {
+	attrs := make([]Attr, 0, len(r.attrs)+3)
+	attrs = append(attrs, r.attrs...)
+	if r.Message != "" {
+		attrs = append(attrs, Event(r.Message))
+	}
+	if r.PC != 0 {
+		attrs = append(attrs, Source(r.PC))
+	}
+
+	if (h.Flags() & FlagPreformatAttrs) == FlagPreformatAttrs {
+		return h.Log(r.Trace, r.Level, attrs)
+	}
+	return h.Log(r.Trace, r.Level, r.front, attrs)
+}
+
+// AdaptRecordHandler returns a Handler that delivers events to h, so that h
+// can be installed into a Tracepoint. Events passed to the Handler's Log
+// method are delivered as Records without a message or call site.
+func AdaptRecordHandler(h RecordHandler) Handler {
+	if hh, ok := h.(Handler); ok {
+		return hh
+	}
+	return &recordHandlerAdapter{h}
+}
+
+type recordHandlerAdapter struct {
+	RecordHandler
+}
+
+func (h *recordHandlerAdapter) Log(tr Trace, l Level, attrs ...[]Attr) error {
+	r := NewRecord(time.Now(), l, "", 0)
+	r.Trace = tr
+	if tr != nil {
+		r.Site = tr.Site()
+	}
+	for _, arr := range attrs {
+		r.AddAttrs(arr...)
+	}
+	return h.Handle(r)
+}
@@ -8,6 +8,9 @@ import (
 )
 
 var _ = Handler(&TextHandler{})
+var _ = RecordHandler(&TextHandler{})
+var _ = LinkHandler(&TextHandler{})
+var _ = Flusher(&TextHandler{})
 
 // TextHandler is a Handler that writes to an io.Writer.
 type TextHandler struct {
@@ -28,6 +31,12 @@ func NewTextHandler(w io.Writer, l Level, includeSourceInfo, includeGoroutineID
 	if includeGoroutineID {
 		flags |= FlagGoroutineID
 	}
+	return NewTextHandlerWithFlags(w, l, flags, reg)
+}
+
+// NewTextHandlerWithFlags creates a TextHandler that writes to w using the
+// options set in flags.
+func NewTextHandlerWithFlags(w io.Writer, l Level, flags HandlerFlags, reg Registry) *TextHandler {
 	if reg == nil {
 		reg = NewRegistry()
 	}
@@ -49,16 +58,34 @@ func (h *TextHandler) Enabled(l Level) bool {
 }
 
 func (h *TextHandler) TraceCreated(tr Trace, attrs []Attr) {
-	h.Log(tr, DebugLevel, []Attr{
+	err := h.log(tr, DebugLevel, false, [][]Attr{{
 		Event("trace created"),
-	}, attrs)
+	}, attrs})
+	ReportError(h, "TraceCreated", err)
 }
 
 func (h *TextHandler) TraceFinished(tr Trace, attrs []Attr) {
-	h.Log(tr, DebugLevel, []Attr{
-		Event("trace finished"),
-		Duration("elapsed", tr.Elapsed()),
-	}, attrs)
+	var err error
+	if (h.flags & FlagPreformatAttrs) == FlagPreformatAttrs {
+		err = h.Log(tr, DebugLevel, []Attr{
+			Event("trace finished"),
+			Duration("elapsed", tr.Elapsed()),
+		}, tr.Status().Attrs(), attrs)
+	} else {
+		err = h.Log(tr, DebugLevel, tr.Attrs(), []Attr{
+			Event("trace finished"),
+			Duration("elapsed", tr.Elapsed()),
+		}, tr.Status().Attrs(), attrs)
+	}
+	ReportError(h, "TraceFinished", err)
+}
+
+func (h *TextHandler) TraceLinked(tr Trace, l Link) {
+	err := h.log(tr, DebugLevel, false, [][]Attr{{
+		Event("trace linked"),
+		LinkTo(l.Context),
+	}, l.Attrs})
+	ReportError(h, "TraceLinked", err)
 }
 
 func (h *TextHandler) Count(tp Tracepoint, delta int64) error {
@@ -124,10 +151,18 @@ type:
     MarshalText is used.
   - Otherwise, the result of fmt.Sprint is used.
 
+If FlagPreformatAttrs is set, the attrs of the trace are formatted once per
+trace and written after the trace's ID.
+
 Each call to Handle results in a single, mutex-protected call to
 io.Writer.Write.
 */
 func (h *TextHandler) Log(tr Trace, l Level, attrs ...[]Attr) error {
+	pre := (h.flags & FlagPreformatAttrs) == FlagPreformatAttrs
+	return h.log(tr, l, pre, attrs)
+}
+
+func (h *TextHandler) log(tr Trace, l Level, pre bool, attrs [][]Attr) error {
 	if l == 0 {
 		return nil
 	}
@@ -138,6 +173,9 @@ func (h *TextHandler) Log(tr Trace, l Level, attrs ...[]Attr) error {
 			String("site", site),
 			Uint64("trace", tr.ID()),
 		)
+		if pre {
+			sb.WriteString(Preformat(tr, h, formatAttrs))
+		}
 		format3(&sb, attrs)
 		return h.finish(&sb)
 	}
@@ -145,6 +183,54 @@ func (h *TextHandler) Log(tr Trace, l Level, attrs ...[]Attr) error {
 	return nil
 }
 
+// Handle formats a Record like Log. The Record's message is written as the
+// "event" attr after the Record's attrs, followed by the "source" of
+// the call site, if known.
+func (h *TextHandler) Handle(r Record) error {
+	if r.Level == 0 {
+		return nil
+	}
+
+	if site, ok := h.reg.IdentifierFor(r.Site); ok {
+		sb := strings.Builder{}
+		h.format(&sb, site, r)
+		return h.finish(&sb)
+	}
+
+	return nil
+}
+
+// format writes r to sb as Handle does, identifying its site as site.
+func (h *TextHandler) format(sb *strings.Builder, site string, r Record) {
+	format2(sb,
+		String("site", site),
+		Uint64("trace", r.Trace.ID()),
+	)
+	if (h.flags & FlagPreformatAttrs) == FlagPreformatAttrs {
+		sb.WriteString(Preformat(r.Trace, h, formatAttrs))
+	}
+	r.Attrs(func(a Attr) bool {
+		format1(sb, a)
+		return true
+	})
+	if r.Message != "" {
+		format1(sb, Event(r.Message))
+	}
+	if r.PC != 0 {
+		format1(sb, Source(r.PC))
+	}
+}
+
+// Flush flushes the io.Writer if it buffers its output, like a bufio.Writer.
+func (h *TextHandler) Flush() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if f, ok := h.w.(Flusher); ok {
+		return f.Flush()
+	}
+	return nil
+}
+
 func (h *TextHandler) finish(sb *strings.Builder) error {
 	sb.WriteString("\n")
 
@@ -159,6 +245,12 @@ func (h *TextHandler) finish(sb *strings.Builder) error {
 	return err
 }
 
+func formatAttrs(attrs []Attr) string {
+	sb := strings.Builder{}
+	format2(&sb, attrs...)
+	return sb.String()
+}
+
 func format3(sb *strings.Builder, attrs [][]Attr) {
 	for _, arr := range attrs {
 		format2(sb, arr...)
@@ -0,0 +1,279 @@
+package influxdb
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dzrw/trace"
+)
+
+var _ = trace.Handler(&InfluxHandler{})
+var _ = trace.AggregateHandler(&InfluxHandler{})
+var _ = trace.Flusher(&InfluxHandler{})
+var _ = trace.Closer(&InfluxHandler{})
+
+// MaxPending is the number of lines an InfluxHandler holds while the server
+// is unreachable. Beyond it, the oldest lines are dropped.
+const MaxPending = 10000
+
+// InfluxHandler is a Handler that writes metrics to InfluxDB in the line
+// protocol over HTTP. Each tracepoint is a measurement named after its
+// identifier, tagged with its metadata's tags. Lines are sent by a background
+// goroutine once batch lines are pending, and on Flush. It is intended to sit
+// behind a trace.Aggregator, but also accepts samples directly. It does not
+// accept events.
+type InfluxHandler struct {
+	url    string
+	batch  int
+	client *http.Client
+	reg    trace.Registry
+
+	mu      sync.Mutex // guards pending
+	pending []string
+
+	send sync.Mutex // serializes flushes
+
+	kick chan struct{} // signals a full batch to run
+	stop chan struct{}
+	done chan struct{}
+	once sync.Once
+}
+
+// New creates an InfluxHandler that posts to writeURL, which is the complete
+// URL of the server's write endpoint, including the database or bucket, e.g.
+// "http://localhost:8086/write?db=metrics". If batch is not positive, lines
+// are only sent on Flush.
+func New(writeURL string, batch int, reg trace.Registry) *InfluxHandler {
+	if reg == nil {
+		reg = trace.NewRegistry()
+	}
+	h := &InfluxHandler{
+		url:    writeURL,
+		batch:  batch,
+		client: &http.Client{Timeout: 10 * time.Second},
+		reg:    reg,
+		kick:   make(chan struct{}, 1),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	if batch > 0 {
+		go h.run()
+	} else {
+		close(h.done)
+	}
+	return h
+}
+
+// run sends full batches, so that the goroutines that record metrics never
+// wait for the server.
+func (h *InfluxHandler) run() {
+	defer close(h.done)
+	for {
+		select {
+		case <-h.kick:
+			trace.ReportError(h, "Flush", h.Flush())
+		case <-h.stop:
+			return
+		}
+	}
+}
+
+func (h *InfluxHandler) Flags() trace.HandlerFlags {
+	return 0
+}
+
+func (h *InfluxHandler) Enabled(l trace.Level) bool {
+	return false
+}
+
+func (h *InfluxHandler) TraceCreated(tr trace.Trace, attrs []trace.Attr) {}
+
+func (h *InfluxHandler) TraceFinished(tr trace.Trace, attrs []trace.Attr) {}
+
+func (h *InfluxHandler) Log(tr trace.Trace, l trace.Level, attrs ...[]trace.Attr) error {
+	return nil
+}
+
+func (h *InfluxHandler) Count(tp trace.Tracepoint, delta int64) error {
+	return h.write(tp, time.Now(), field{"count", integer(delta)})
+}
+
+func (h *InfluxHandler) Gauge(tp trace.Tracepoint, value int64) error {
+	return h.write(tp, time.Now(), field{"gauge", integer(value)})
+}
+
+func (h *InfluxHandler) Duration(tp trace.Tracepoint, d time.Duration) error {
+	return h.write(tp, time.Now(), field{"duration_ms", millis(d)})
+}
+
+func (h *InfluxHandler) Histogram(tp trace.Tracepoint, sample int64) error {
+	return h.write(tp, time.Now(), field{"histogram", integer(sample)})
+}
+
+// Aggregate writes the metrics accumulated by a trace.Aggregator as one line
+// per tracepoint and sends them immediately.
+func (h *InfluxHandler) Aggregate(arr []trace.Metrics) error {
+	now := time.Now()
+	for _, m := range arr {
+		var fields []field
+		if m.Count != 0 {
+			fields = append(fields, field{"count", integer(m.Count)})
+		}
+		if m.Errors != 0 {
+			fields = append(fields, field{"errors", integer(m.Errors)})
+		}
+		if m.Gauge.N > 0 {
+			fields = append(fields,
+				field{"gauge", integer(m.Gauge.Last)},
+				field{"gauge_min", integer(m.Gauge.Min)},
+				field{"gauge_max", integer(m.Gauge.Max)})
+		}
+		if m.Duration.N > 0 {
+			fields = append(fields,
+				field{"duration_count", integer(m.Duration.N)},
+				field{"duration_mean_ms", millis(m.Duration.Mean())},
+				field{"duration_min_ms", millis(m.Duration.Min)},
+				field{"duration_max_ms", millis(m.Duration.Max)})
+		}
+		h.write(m.Site, now, fields...)
+	}
+	return h.Flush()
+}
+
+type field struct {
+	name  string
+	value string
+}
+
+func (h *InfluxHandler) write(tp trace.Tracepoint, ts time.Time, fields ...field) error {
+	id, ok := h.reg.IdentifierFor(tp)
+	if !ok || len(fields) == 0 {
+		return nil
+	}
+
+	sb := strings.Builder{}
+	sb.WriteString(escape(id, ", "))
+	if md, ok := h.reg.MetadataFor(tp); ok {
+		keys := make([]string, 0, len(md.Tags))
+		for k := range md.Tags {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if v := md.Tags[k]; v != "" {
+				sb.WriteString("," + escape(k, ",= ") + "=" + escape(v, ",= "))
+			}
+		}
+	}
+	for i, f := range fields {
+		if i == 0 {
+			sb.WriteRune(' ')
+		} else {
+			sb.WriteRune(',')
+		}
+		sb.WriteString(escape(f.name, ",= ") + "=" + f.value)
+	}
+	sb.WriteRune(' ')
+	sb.WriteString(strconv.FormatInt(ts.UnixNano(), 10))
+	sb.WriteRune('\n')
+
+	h.mu.Lock()
+	h.pending = append(h.pending, sb.String())
+	if over := len(h.pending) - MaxPending; over > 0 {
+		h.pending = h.pending[over:]
+	}
+	full := h.batch > 0 && len(h.pending) >= h.batch
+	h.mu.Unlock()
+
+	if full {
+		select {
+		case h.kick <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// Flush sends the pending lines. If the request fails, the lines are kept for
+// the next flush. Metrics recorded during the flush are not held up by it.
+func (h *InfluxHandler) Flush() error {
+	h.send.Lock()
+	defer h.send.Unlock()
+
+	h.mu.Lock()
+	lines := h.pending
+	h.pending = nil
+	h.mu.Unlock()
+
+	retry, err := h.flush(lines)
+	if err != nil && retry {
+		h.mu.Lock()
+		h.pending = append(lines, h.pending...)
+		if over := len(h.pending) - MaxPending; over > 0 {
+			h.pending = h.pending[over:]
+		}
+		h.mu.Unlock()
+	}
+	return err
+}
+
+// flush posts lines. If it fails, it reports whether the lines may be
+// accepted by a later attempt.
+func (h *InfluxHandler) flush(lines []string) (retry bool, err error) {
+	if len(lines) == 0 {
+		return false, nil
+	}
+
+	body := strings.NewReader(strings.Join(lines, ""))
+	resp, err := h.client.Post(h.url, "text/plain; charset=utf-8", body)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		err = fmt.Errorf("influxdb: POST %s: %s: %s", h.url, resp.Status, bytes.TrimSpace(msg))
+		// The server will never accept lines it rejected as invalid.
+		return resp.StatusCode < 400 || resp.StatusCode > 499, err
+	}
+	return false, nil
+}
+
+// Close stops the background goroutine and sends the pending lines.
+func (h *InfluxHandler) Close() error {
+	h.once.Do(func() { close(h.stop) })
+	<-h.done
+	return h.Flush()
+}
+
+func integer(v int64) string {
+	return strconv.FormatInt(v, 10) + "i"
+}
+
+func millis(d time.Duration) string {
+	return strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', -1, 64)
+}
+
+// escape backslash-escapes the characters in special, as the line protocol
+// requires for measurement names, tag keys and values, and field keys.
+func escape(s, special string) string {
+	if !strings.ContainsAny(s, special) {
+		return s
+	}
+	sb := strings.Builder{}
+	for _, r := range s {
+		if strings.ContainsRune(special, r) {
+			sb.WriteRune('\\')
+		}
+		sb.WriteRune(r)
+	}
+	return sb.String()
+}
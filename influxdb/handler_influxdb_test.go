@@ -0,0 +1,87 @@
+package influxdb_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dzrw/trace"
+	"github.com/dzrw/trace/influxdb"
+	"github.com/stretchr/testify/require"
+)
+
+var SiteTestHandler = trace.Site()
+
+func TestInfluxHandler(t *testing.T) {
+	bodies := make(chan string, 4)
+	status := http.StatusServiceUnavailable
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		bodies <- string(b)
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	reg := trace.NewRegistry()
+	reg.Define(SiteTestHandler, "influxdb_test.Site,Test")
+	reg.Describe(SiteTestHandler, trace.Metadata{Tags: map[string]string{"host": "a b"}})
+
+	h := influxdb.New(srv.URL+"/write?db=test", 0, reg)
+	agg := trace.NewAggregator(h, 0)
+	SiteTestHandler.Install(agg)
+	defer SiteTestHandler.Uninstall()
+
+	SiteTestHandler.Gauge(4)
+	SiteTestHandler.Duration(2 * time.Millisecond)
+
+	// The server is unavailable, so the lines are kept for the next flush.
+	require.Error(t, agg.Flush())
+	first := <-bodies
+
+	status = http.StatusNoContent
+	require.NoError(t, h.Flush())
+	require.Equal(t, first, <-bodies)
+
+	fields := strings.Fields(strings.ReplaceAll(first, `\ `, "_"))
+	require.Len(t, fields, 3)
+	require.Equal(t, `influxdb_test.Site\,Test,host=a_b`, fields[0])
+	require.Equal(t, "gauge=4i,gauge_min=4i,gauge_max=4i,duration_count=1i,duration_mean_ms=2,duration_min_ms=2,duration_max_ms=2", fields[1])
+}
+
+func TestInfluxHandlerBatch(t *testing.T) {
+	release := make(chan struct{})
+	bodies := make(chan string, 4)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		b, _ := io.ReadAll(r.Body)
+		bodies <- string(b)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	reg := trace.NewRegistry()
+	reg.Define(SiteTestHandler, "influxdb_test.batch")
+
+	h := influxdb.New(srv.URL+"/write?db=test", 1, reg)
+	defer h.Close()
+
+	// A full batch is sent in the background, so a stalled server does not
+	// hold up the caller.
+	done := make(chan struct{})
+	go func() {
+		require.NoError(t, h.Count(SiteTestHandler, 1))
+		require.NoError(t, h.Count(SiteTestHandler, 2))
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		require.Fail(t, "Count waited for the server")
+	}
+
+	close(release)
+	require.Contains(t, <-bodies, "influxdb_test.batch count=1i")
+}
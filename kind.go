@@ -18,6 +18,9 @@ const (
 	StringKind
 	TimeKind
 	Uint64Kind
+	LinkKind
+	StackKind
+	SourceKind
 )
 
 func (k Kind) String() string {
@@ -38,6 +41,12 @@ func (k Kind) String() string {
 		return "time.Time"
 	case Uint64Kind:
 		return "uint64"
+	case LinkKind:
+		return "trace.RemoteContext"
+	case StackKind:
+		return "[]trace.Frame"
+	case SourceKind:
+		return "trace.Frame"
 	case AnyKind:
 		fallthrough
 	default:
@@ -0,0 +1,262 @@
+package trace
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// LeakKind classifies a LeakReport.
+type LeakKind int
+
+const (
+	LeakOpen        LeakKind = iota + 1 // LeakOpen is a trace open longer than the threshold.
+	LeakCollected                       // LeakCollected is a trace collected without being closed.
+	LeakClosedTwice                     // LeakClosedTwice is a trace closed more than once.
+)
+
+func (k LeakKind) String() string {
+	switch k {
+	case LeakOpen:
+		return "open"
+	case LeakCollected:
+		return "collected"
+	case LeakClosedTwice:
+		return "closed twice"
+	default:
+		return fmt.Sprintf("LeakKind(%d)", int(k))
+	}
+}
+
+// A LeakReport describes a trace that was not closed exactly once.
+type LeakReport struct {
+	Kind  LeakKind
+	Site  Tracepoint
+	Trace uint64        // Trace is the ID of the trace.
+	Age   time.Duration // Age is the time since the trace was created.
+
+	// Stack is the symbolized stack of the goroutine that created the
+	// trace, one "function\n\tfile:line" frame per line pair.
+	Stack string
+
+	// CloseStack is the stack of the goroutine that closed the trace again,
+	// for LeakClosedTwice.
+	CloseStack string
+}
+
+func (r LeakReport) String() string {
+	sb := strings.Builder{}
+	fmt.Fprintf(&sb, "trace: leak: %s trace site=%s trace=%d age=%s\ncreated at:\n%s", r.Kind, r.Site.ID(), r.Trace, r.Age, r.Stack)
+	if r.CloseStack != "" {
+		fmt.Fprintf(&sb, "closed again at:\n%s", r.CloseStack)
+	}
+	return sb.String()
+}
+
+// LeakOptions configure a LeakDetector.
+type LeakOptions struct {
+	// Threshold is the age beyond which an open trace is reported, once. If
+	// it is zero, open traces are only reported by Check.
+	Threshold time.Duration
+
+	// Interval is how often open traces are checked against Threshold, or
+	// Threshold/2 if zero.
+	Interval time.Duration
+
+	// Report receives the reports. If it is nil, reports are written to
+	// stderr.
+	Report func(LeakReport)
+}
+
+/*
+LeakDetector tracks the traces created while it is running, to report those
+that are left open too long, collected without being closed, or closed twice.
+Recording the stack of every trace is expensive, so a LeakDetector is meant
+for tests and debugging.
+
+	d := trace.StartLeakDetector(trace.LeakOptions{Threshold: time.Minute})
+	defer d.Close()
+*/
+type LeakDetector struct {
+	opts LeakOptions
+
+	mu   sync.Mutex
+	open map[*leakentry]struct{}
+
+	stop chan struct{}
+	done chan struct{}
+	once sync.Once
+}
+
+// leakentry records a tracked trace. It must not refer to the trace, so that
+// the trace can be collected.
+type leakentry struct {
+	d     *LeakDetector
+	site  Tracepoint
+	id    uint64
+	then  time.Time
+	stack []uintptr
+
+	reported bool // guarded by d.mu; set once reported as LeakOpen by run
+}
+
+var leakDetector atomic.Pointer[LeakDetector]
+
+// StartLeakDetector starts tracking the traces created from now on,
+// replacing any running LeakDetector.
+func StartLeakDetector(opts LeakOptions) *LeakDetector {
+	if opts.Report == nil {
+		opts.Report = func(r LeakReport) { fmt.Fprintln(os.Stderr, r) }
+	}
+	if opts.Interval <= 0 {
+		opts.Interval = opts.Threshold / 2
+	}
+
+	d := &LeakDetector{
+		opts: opts,
+		open: make(map[*leakentry]struct{}),
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	if opts.Threshold > 0 && opts.Interval > 0 {
+		go d.run()
+	} else {
+		close(d.done)
+	}
+
+	if old := leakDetector.Swap(d); old != nil {
+		old.Close()
+	}
+	return d
+}
+
+func (d *LeakDetector) run() {
+	defer close(d.done)
+
+	ticker := time.NewTicker(d.opts.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			for _, r := range d.check(d.opts.Threshold, true) {
+				d.opts.Report(r)
+			}
+		case <-d.stop:
+			return
+		}
+	}
+}
+
+// Check returns a report for each tracked trace that is open and older than
+// age, oldest first.
+func (d *LeakDetector) Check(age time.Duration) []LeakReport {
+	return d.check(age, false)
+}
+
+func (d *LeakDetector) check(age time.Duration, once bool) []LeakReport {
+	now := time.Now()
+	var found []*leakentry
+
+	d.mu.Lock()
+	for e := range d.open {
+		if now.Sub(e.then) > age && !(once && e.reported) {
+			e.reported = e.reported || once
+			found = append(found, e)
+		}
+	}
+	d.mu.Unlock()
+
+	arr := make([]LeakReport, len(found))
+	for i, e := range found {
+		arr[i] = e.report(LeakOpen, now)
+	}
+	sort.Slice(arr, func(i, j int) bool { return arr[i].Age > arr[j].Age })
+	return arr
+}
+
+// Close stops tracking new traces. Traces already tracked are still reported
+// if they are collected without being closed or closed twice.
+func (d *LeakDetector) Close() error {
+	leakDetector.CompareAndSwap(d, nil)
+	d.once.Do(func() { close(d.stop) })
+	<-d.done
+	return nil
+}
+
+// track records the creation of tr, skipping skip frames of the stack.
+func (d *LeakDetector) track(tr *traceimpl, skip int) {
+	var pcs [32]uintptr
+	n := runtime.Callers(skip+1, pcs[:])
+
+	e := &leakentry{
+		d:     d,
+		site:  tr.tp,
+		id:    tr.id,
+		then:  tr.then,
+		stack: append([]uintptr(nil), pcs[:n]...),
+	}
+	d.mu.Lock()
+	d.open[e] = struct{}{}
+	d.mu.Unlock()
+
+	tr.st.leak = e
+	runtime.SetFinalizer(tr.st, func(st *tracestate) {
+		st.leak.collected()
+	})
+}
+
+// closed is called when the trace is closed for the nth time.
+func (e *leakentry) closed(n int) {
+	e.d.mu.Lock()
+	delete(e.d.open, e)
+	e.d.mu.Unlock()
+
+	if n == 2 {
+		r := e.report(LeakClosedTwice, time.Now())
+		var pcs [32]uintptr
+		r.CloseStack = formatStack(pcs[:runtime.Callers(3, pcs[:])])
+		e.d.opts.Report(r)
+	}
+}
+
+// collected is called when the trace is garbage collected.
+func (e *leakentry) collected() {
+	e.d.mu.Lock()
+	_, open := e.d.open[e]
+	delete(e.d.open, e)
+	e.d.mu.Unlock()
+
+	if open {
+		e.d.opts.Report(e.report(LeakCollected, time.Now()))
+	}
+}
+
+func (e *leakentry) report(kind LeakKind, now time.Time) LeakReport {
+	return LeakReport{
+		Kind:  kind,
+		Site:  e.site,
+		Trace: e.id,
+		Age:   now.Sub(e.then),
+		Stack: formatStack(e.stack),
+	}
+}
+
+func formatStack(pcs []uintptr) string {
+	sb := strings.Builder{}
+	frames := runtime.CallersFrames(pcs)
+	for {
+		f, more := frames.Next()
+		if f.Function != "" {
+			fmt.Fprintf(&sb, "%s\n\t%s:%d\n", f.Function, f.File, f.Line)
+		}
+		if !more {
+			return sb.String()
+		}
+	}
+}
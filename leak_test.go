@@ -0,0 +1,76 @@
+package trace_test
+
+import (
+	"io"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dzrw/trace"
+	"github.com/stretchr/testify/require"
+)
+
+var SiteTestLeak = trace.Site()
+
+func TestLeakDetector(t *testing.T) {
+	SiteTestLeak.Install(trace.NewTextHandler(io.Discard, trace.ErrorLevel, false, false, nil))
+	defer SiteTestLeak.Uninstall()
+
+	var mu sync.Mutex
+	var reports []trace.LeakReport
+	d := trace.StartLeakDetector(trace.LeakOptions{
+		Threshold: 10 * time.Millisecond,
+		Interval:  time.Millisecond,
+		Report: func(r trace.LeakReport) {
+			mu.Lock()
+			reports = append(reports, r)
+			mu.Unlock()
+		},
+	})
+	defer d.Close()
+	collect := func(kind trace.LeakKind) []trace.LeakReport {
+		mu.Lock()
+		defer mu.Unlock()
+		var arr []trace.LeakReport
+		for _, r := range reports {
+			if r.Kind == kind {
+				arr = append(arr, r)
+			}
+		}
+		return arr
+	}
+
+	open := SiteTestLeak.Trace()
+	closed := SiteTestLeak.Trace()
+	closed.Close()
+
+	arr := d.Check(0)
+	require.Len(t, arr, 1)
+	require.Equal(t, open.ID(), arr[0].Trace)
+	require.Contains(t, arr[0].Stack, "trace_test.TestLeakDetector")
+
+	require.Eventually(t, func() bool { return len(collect(trace.LeakOpen)) == 1 }, time.Second, time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	require.Len(t, collect(trace.LeakOpen), 1, "open traces are reported once")
+	open.Close()
+	require.Empty(t, d.Check(0))
+
+	closed.Close()
+	twice := collect(trace.LeakClosedTwice)
+	require.Len(t, twice, 1)
+	require.Equal(t, closed.ID(), twice[0].Trace)
+	require.Contains(t, twice[0].CloseStack, "trace_test.TestLeakDetector")
+
+	func() {
+		SiteTestLeak.Trace()
+	}()
+	require.Eventually(t, func() bool {
+		runtime.GC()
+		return len(collect(trace.LeakCollected)) == 1
+	}, time.Second, time.Millisecond)
+
+	d.Close()
+	SiteTestLeak.Trace()
+	require.Empty(t, d.Check(0), "traces created after Close are not tracked")
+}
@@ -1,6 +1,7 @@
 package trace
 
 import (
+	"fmt"
 	"strconv"
 	"strings"
 )
@@ -63,3 +64,42 @@ func (l Level) String() string {
 		return sb.String()
 	}
 }
+
+/*
+ParseLevel returns the level named by s, which is either a name returned by
+Level.String, in any case, or an integer. The name "NOISE" is accepted for
+NoiseLevel. Examples:
+
+	ParseLevel("warn") => WarnLevel
+	ParseLevel("WARN-25") => Level(25)
+	ParseLevel("31") => DebugLevel
+*/
+func ParseLevel(s string) (Level, error) {
+	name := strings.ToUpper(strings.TrimSpace(s))
+	switch name {
+	case "ERROR":
+		return ErrorLevel, nil
+	case "ASSERT":
+		return AssertionViolatedLevel, nil
+	case "WARN":
+		return WarnLevel, nil
+	case "INFO":
+		return InfoLevel, nil
+	case "DEBUG":
+		return DebugLevel, nil
+	case "NOISE":
+		return NoiseLevel, nil
+	}
+
+	if i := strings.LastIndexByte(name, '-'); i > 0 {
+		switch name[:i] {
+		case "ERROR", "WARN", "INFO", "DEBUG":
+			name = name[i+1:]
+		}
+	}
+	n, err := strconv.Atoi(name)
+	if err != nil {
+		return 0, fmt.Errorf("trace: invalid level %q", s)
+	}
+	return Level(n), nil
+}
@@ -0,0 +1,158 @@
+package trace
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// A Flusher is a Handler that buffers data and can write it out on demand.
+type Flusher interface {
+	// Flush writes out any buffered data.
+	Flush() error
+}
+
+// A Closer is a Handler that holds resources that must be released.
+type Closer interface {
+	// Close writes out any buffered data and releases the handler's
+	// resources. A Handler that wraps another Handler closes it too.
+	Close() error
+}
+
+// HandlerError records a Handler that failed to drain during Shutdown.
+type HandlerError struct {
+	Handler Handler
+	Err     error
+}
+
+func (e HandlerError) Error() string {
+	return fmt.Sprintf("%T: %v", e.Handler, e.Err)
+}
+
+func (e HandlerError) Unwrap() error {
+	return e.Err
+}
+
+// ShutdownError is returned by Shutdown when one or more handlers failed to
+// drain.
+type ShutdownError struct {
+	Errors []HandlerError
+}
+
+func (e *ShutdownError) Error() string {
+	arr := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		arr[i] = err.Error()
+	}
+	return "trace: shutdown: " + strings.Join(arr, "; ")
+}
+
+// Is reports whether the error of any handler that failed to drain matches
+// target, so that errors.Is looks through a ShutdownError.
+func (e *ShutdownError) Is(target error) bool {
+	for _, err := range e.Errors {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// As finds the first error of a handler that failed to drain that matches
+// target, so that errors.As looks through a ShutdownError.
+func (e *ShutdownError) As(target any) bool {
+	for _, err := range e.Errors {
+		if errors.As(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+Shutdown uninstalls the handlers installed into every Tracepoint created by
+Site, then flushes and closes each of those handlers at most once. Handlers
+are drained concurrently.
+
+If ctx is done before every handler has drained, Shutdown returns without
+waiting for the rest. The returned error is a *ShutdownError that lists each
+handler that failed or did not drain in time, or nil.
+*/
+func Shutdown(ctx context.Context) error {
+	var handlers []Handler
+	for _, tp := range sites.all() {
+		if h, ok := tp.Handler(); ok {
+			tp.Uninstall()
+			if !containsHandler(handlers, h) {
+				handlers = append(handlers, h)
+			}
+		}
+	}
+	return drain(ctx, handlers)
+}
+
+// drain flushes and closes handlers concurrently and waits for them to
+// finish or for ctx to be done.
+func drain(ctx context.Context, handlers []Handler) error {
+	results := make(chan HandlerError, len(handlers))
+	for _, h := range handlers {
+		go func(h Handler) {
+			results <- HandlerError{Handler: h, Err: drainOne(h)}
+		}(h)
+	}
+
+	var failed []HandlerError
+	pending := append([]Handler(nil), handlers...)
+	for len(pending) > 0 {
+		select {
+		case res := <-results:
+			pending = removeHandler(pending, res.Handler)
+			if res.Err != nil {
+				failed = append(failed, res)
+			}
+		case <-ctx.Done():
+			for _, h := range pending {
+				failed = append(failed, HandlerError{Handler: h, Err: ctx.Err()})
+			}
+			pending = nil
+		}
+	}
+
+	if len(failed) > 0 {
+		return &ShutdownError{Errors: failed}
+	}
+	return nil
+}
+
+func drainOne(h Handler) error {
+	var err error
+	if f, ok := h.(Flusher); ok {
+		err = f.Flush()
+	}
+	if c, ok := h.(Closer); ok {
+		if cerr := c.Close(); err == nil {
+			err = cerr
+		}
+	}
+	forgetErrors(h)
+	return err
+}
+
+func containsHandler(arr []Handler, h Handler) bool {
+	for _, v := range arr {
+		if v == h {
+			return true
+		}
+	}
+	return false
+}
+
+func removeHandler(arr []Handler, h Handler) []Handler {
+	for i, v := range arr {
+		if v == h {
+			return append(arr[:i], arr[i+1:]...)
+		}
+	}
+	return arr
+}
@@ -0,0 +1,67 @@
+package trace_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/dzrw/trace"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	SiteTestShutdownA = trace.Site()
+	SiteTestShutdownB = trace.Site()
+	SiteTestShutdownC = trace.Site()
+)
+
+type lifecycleRecorder struct {
+	metricsRecorder
+	flushed, closed int
+	err             error
+	block           chan struct{}
+}
+
+func (h *lifecycleRecorder) Flush() error {
+	h.flushed++
+	return nil
+}
+
+func (h *lifecycleRecorder) Close() error {
+	if h.block != nil {
+		<-h.block
+	}
+	h.closed++
+	return h.err
+}
+
+func TestShutdown(t *testing.T) {
+	ok := &lifecycleRecorder{metricsRecorder: *newMetricsRecorder()}
+	broken := &lifecycleRecorder{metricsRecorder: *newMetricsRecorder(), err: errors.New("broken")}
+	stuck := &lifecycleRecorder{metricsRecorder: *newMetricsRecorder(), block: make(chan struct{})}
+	defer close(stuck.block)
+
+	SiteTestShutdownA.Install(ok)
+	SiteTestShutdownB.Install(ok)
+	SiteTestShutdownC.Install(broken)
+	agg := trace.NewAggregator(stuck, 0)
+	SiteTestAggregator.Install(agg)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err := trace.Shutdown(ctx)
+
+	var se *trace.ShutdownError
+	require.ErrorAs(t, err, &se)
+	require.Len(t, se.Errors, 2)
+	require.ElementsMatch(t, []trace.Handler{broken, agg}, []trace.Handler{se.Errors[0].Handler, se.Errors[1].Handler})
+	require.True(t, errors.Is(err, context.DeadlineExceeded))
+
+	require.Equal(t, 1, ok.flushed)
+	require.Equal(t, 1, ok.closed)
+	require.Equal(t, 1, broken.closed)
+
+	_, installed := SiteTestShutdownA.Handler()
+	require.False(t, installed)
+}
@@ -0,0 +1,81 @@
+package trace
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/segmentio/ksuid"
+)
+
+// RemoteContext identifies a trace, possibly one in another process. It is
+// used to link a trace to the traces that caused it.
+type RemoteContext struct {
+	Site  ksuid.KSUID // Site is the ID of the tracepoint that originated the trace.
+	Trace uint64      // Trace is the ID of the trace.
+}
+
+// RemoteContextOf returns the RemoteContext that identifies tr.
+func RemoteContextOf(tr Trace) RemoteContext {
+	rc := RemoteContext{Trace: tr.ID()}
+	if tp := tr.Site(); tp != nil {
+		rc.Site = tp.ID()
+	}
+	return rc
+}
+
+// ParseRemoteContext parses the string representation of a RemoteContext, as
+// returned by RemoteContext.String.
+func ParseRemoteContext(s string) (RemoteContext, error) {
+	site, trace, ok := strings.Cut(s, ":")
+	if !ok {
+		return RemoteContext{}, fmt.Errorf("invalid remote context %q", s)
+	}
+	id, err := ksuid.Parse(site)
+	if err != nil {
+		return RemoteContext{}, fmt.Errorf("invalid remote context %q: %w", s, err)
+	}
+	n, err := strconv.ParseUint(trace, 10, 64)
+	if err != nil {
+		return RemoteContext{}, fmt.Errorf("invalid remote context %q: %w", s, err)
+	}
+	return RemoteContext{Site: id, Trace: n}, nil
+}
+
+// IsZero returns true if rc does not identify a trace.
+func (rc RemoteContext) IsZero() bool {
+	return rc.Site.IsNil() && rc.Trace == 0
+}
+
+// String returns rc as "site:trace", which is suitable for carrying across
+// process boundaries, e.g. in a job's payload.
+func (rc RemoteContext) String() string {
+	return rc.Site.String() + ":" + strconv.FormatUint(rc.Trace, 10)
+}
+
+// A Link records that a trace was caused by another trace, which is not its
+// parent. For example, a worker's trace may link to the trace of the request
+// that enqueued its job.
+type Link struct {
+	Context RemoteContext // Context identifies the linked trace.
+	Attrs   []Attr        // Attrs describe the link.
+}
+
+// LinkTo returns an Attr that, when passed to Tracepoint.Trace, links the new
+// trace to the trace identified by rc.
+func LinkTo(rc RemoteContext) Attr {
+	u := rc
+	return Attr{key: "link", val: &u, kind: LinkKind}
+}
+
+func (st *tracestate) addLink(l Link) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.links = append(st.links, l)
+}
+
+func (st *tracestate) getLinks() []Link {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return append([]Link(nil), st.links...)
+}
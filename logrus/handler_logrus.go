@@ -8,6 +8,8 @@ import (
 )
 
 var _ = trace.Handler(&LogrusHandler{})
+var _ = trace.RecordHandler(&LogrusHandler{})
+var _ = trace.LinkHandler(&LogrusHandler{})
 
 type LogrusHandler struct {
 	minLevel trace.Level
@@ -46,10 +48,17 @@ func (h *LogrusHandler) TraceCreated(tr trace.Trace, attrs []trace.Attr) {
 }
 
 func (h *LogrusHandler) TraceFinished(tr trace.Trace, attrs []trace.Attr) {
-	h.Log(tr, trace.DebugLevel, []trace.Attr{
+	h.Log(tr, trace.DebugLevel, tr.Attrs(), []trace.Attr{
 		trace.Event("trace finished"),
 		trace.Duration("elapsed", tr.Elapsed()),
-	}, attrs)
+	}, tr.Status().Attrs(), attrs)
+}
+
+func (h *LogrusHandler) TraceLinked(tr trace.Trace, l trace.Link) {
+	h.Log(tr, trace.DebugLevel, []trace.Attr{
+		trace.Event("trace linked"),
+		trace.LinkTo(l.Context),
+	}, l.Attrs)
 }
 
 func (h *LogrusHandler) Count(tp trace.Tracepoint, delta int64) error {
@@ -111,6 +120,34 @@ func (h *LogrusHandler) Log(tr trace.Trace, l trace.Level, attrs ...[]trace.Attr
 	return nil
 }
 
+func (h *LogrusHandler) Handle(r trace.Record) error {
+	if r.Level == 0 {
+		return nil
+	}
+
+	if site, ok := h.reg.IdentifierFor(r.Site); ok {
+		f := make(log.Fields)
+		format2(f,
+			trace.String("site", site),
+			trace.Uint64("trace", r.Trace.ID()),
+		)
+		r.Attrs(func(a trace.Attr) bool {
+			format1(f, a)
+			return true
+		})
+		if r.Message != "" {
+			format1(f, trace.Event(r.Message))
+		}
+		if r.PC != 0 {
+			format1(f, trace.Source(r.PC))
+		}
+		e := log.NewEntry(h.logger).WithFields(f).WithTime(r.Time)
+		e.Log(makeLogrusLevel(r.Level))
+	}
+
+	return nil
+}
+
 func makeTraceLevel(l log.Level) trace.Level {
 	switch l {
 	case log.ErrorLevel:
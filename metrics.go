@@ -0,0 +1,67 @@
+package trace
+
+import "time"
+
+// Metrics summarizes the counters, gauges and durations captured by a
+// tracepoint, and the failed traces it originated.
+type Metrics struct {
+	Site     Tracepoint      // Site is the tracepoint that captured the metrics.
+	Count    int64           // Count is the sum of the counter deltas.
+	Gauge    GaugeSummary    // Gauge summarizes the gauge values.
+	Duration DurationSummary // Duration summarizes the durations.
+	Errors   int64           // Errors is the number of traces that finished with a failed Status.
+}
+
+// GaugeSummary summarizes a series of gauge values.
+type GaugeSummary struct {
+	N    int64 // N is the number of values observed.
+	Last int64 // Last is the most recently observed value.
+	Min  int64 // Min is the smallest observed value.
+	Max  int64 // Max is the largest observed value.
+}
+
+func (s *GaugeSummary) observe(value int64) {
+	if s.N == 0 || value < s.Min {
+		s.Min = value
+	}
+	if s.N == 0 || value > s.Max {
+		s.Max = value
+	}
+	s.Last = value
+	s.N++
+}
+
+// DurationSummary summarizes a series of durations.
+type DurationSummary struct {
+	N   int64         // N is the number of durations observed.
+	Sum time.Duration // Sum is the total of the observed durations.
+	Min time.Duration // Min is the shortest observed duration.
+	Max time.Duration // Max is the longest observed duration.
+}
+
+func (s *DurationSummary) observe(d time.Duration) {
+	if s.N == 0 || d < s.Min {
+		s.Min = d
+	}
+	if s.N == 0 || d > s.Max {
+		s.Max = d
+	}
+	s.Sum += d
+	s.N++
+}
+
+// Mean returns the average of the observed durations, or zero if none were
+// observed.
+func (s DurationSummary) Mean() time.Duration {
+	if s.N == 0 {
+		return 0
+	}
+	return s.Sum / time.Duration(s.N)
+}
+
+// An AggregateHandler accepts metrics that were aggregated over an interval.
+// An Aggregator prefers this interface over the per-sample Handler methods
+// when flushing to a downstream handler.
+type AggregateHandler interface {
+	Aggregate([]Metrics) error
+}
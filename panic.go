@@ -0,0 +1,93 @@
+package trace
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+)
+
+// A PanicPolicy decides what Trace.Recover does with a panic once it has
+// been recorded.
+type PanicPolicy int32
+
+const (
+	PanicPropagate PanicPolicy = iota // PanicPropagate panics again with the same value.
+	PanicSwallow                      // PanicSwallow stops the panic.
+)
+
+var panicPolicy int32 // holds a PanicPolicy
+
+// SetPanicPolicy sets the policy of Trace.Recover. The default is
+// PanicPropagate.
+func SetPanicPolicy(p PanicPolicy) {
+	atomic.StoreInt32(&panicPolicy, int32(p))
+}
+
+// CurrentPanicPolicy returns the policy set by SetPanicPolicy.
+func CurrentPanicPolicy() PanicPolicy {
+	return PanicPolicy(atomic.LoadInt32(&panicPolicy))
+}
+
+// PanicError is the error recorded as the status of a trace by Recover.
+type PanicError struct {
+	Value any    // Value is the value passed to panic.
+	Stack []byte // Stack is the stack of the panicking goroutine.
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.Value)
+}
+
+// Unwrap returns the value passed to panic if it is an error.
+func (e *PanicError) Unwrap() error {
+	err, _ := e.Value.(error)
+	return err
+}
+
+func (*noptraceimpl) Recover() {
+	if v := recover(); v != nil && CurrentPanicPolicy() == PanicPropagate {
+		panic(v)
+	}
+}
+
+func (tr *traceimpl) Recover() {
+	v := recover()
+	if v == nil {
+		tr.Close()
+		return
+	}
+
+	// The event's source is the function that panicked, below Recover and
+	// the frames of the runtime.
+	frames, dropped := capturePanicStack(1)
+	err := &PanicError{Value: v, Stack: []byte(formatFrames(frames))}
+	tr.tp.log(tr.ctx, tr, 2+dropped, ErrorLevel, "panic", []Attr{Error(err), StackTrace(frames)})
+	tr.CloseWithError(err)
+
+	if CurrentPanicPolicy() == PanicPropagate {
+		panic(v)
+	}
+}
+
+/*
+Go originates a Trace from site and calls fn with it in a new goroutine. The
+trace is closed when fn returns, and a panic in fn is recovered by the trace
+as by Recover:
+
+	trace.Go(SiteWorker, func(tr trace.Trace) {
+		tr.Info("working")
+	}, trace.Int("job", id))
+*/
+func Go(site Tracepoint, fn func(Trace), attrs ...Attr) {
+	var tr Trace
+	if tp, ok := site.(*tracepoint); ok {
+		tr = tp.trace(context.Background(), 2, attrs)
+	} else {
+		tr = site.Trace(attrs...)
+	}
+
+	go func() {
+		defer tr.Recover()
+		fn(tr)
+	}()
+}
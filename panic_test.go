@@ -0,0 +1,144 @@
+package trace_test
+
+import (
+	"bytes"
+	"errors"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dzrw/trace"
+	"github.com/stretchr/testify/require"
+)
+
+var SiteTestPanic = trace.Site()
+
+func TestRecover(t *testing.T) {
+	reg := trace.NewRegistry()
+	reg.Define(SiteTestPanic, "panic")
+	buf := bytes.Buffer{}
+	SiteTestPanic.Install(trace.NewTextHandler(&buf, trace.ErrorLevel, true, false, reg))
+	defer SiteTestPanic.Uninstall()
+
+	errBoom := errors.New("boom")
+	var tr trace.Trace
+	func() {
+		defer func() { require.Equal(t, errBoom, recover()) }()
+		tr = SiteTestPanic.Trace()
+		defer tr.Recover()
+		panic(errBoom)
+	}()
+
+	st := tr.Status()
+	require.Equal(t, trace.StatusError, st.Code)
+	require.Equal(t, "panic: boom", st.Description)
+	require.ErrorIs(t, st.Err, errBoom)
+	var pe *trace.PanicError
+	require.ErrorAs(t, st.Err, &pe)
+	require.Contains(t, string(pe.Stack), "trace_test.TestRecover")
+
+	out := buf.String()
+	require.Contains(t, out, `error="panic: boom"`)
+	require.Contains(t, out, "event=panic")
+	require.Contains(t, out, `stack="github.com/dzrw/trace_test.TestRecover.func1(panic_test.go:31)`)
+	require.Contains(t, out, "panic_test.go")
+
+	trace.SetPanicPolicy(trace.PanicSwallow)
+	defer trace.SetPanicPolicy(trace.PanicPropagate)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	var gtr trace.Trace
+	trace.Go(SiteTestPanic, func(tr trace.Trace) {
+		defer wg.Done()
+		gtr = tr
+		panic("in goroutine")
+	})
+	wg.Wait()
+	require.Eventually(t, func() bool { return gtr.Status().Failed() }, time.Second, time.Millisecond)
+	require.Equal(t, "panic: in goroutine", gtr.Status().Description)
+
+	ok := SiteTestPanic.Trace()
+	func() {
+		defer ok.Recover()
+	}()
+	require.Equal(t, trace.StatusOK, ok.Status().Code, "Recover closes the trace without a panic")
+}
+
+// deref panics with a runtime error below depth frames of recursion.
+func deref(depth int, p *int) int {
+	if depth > 0 {
+		return deref(depth-1, p) + 1
+	}
+	return *p
+}
+
+func TestRecoverRuntimePanic(t *testing.T) {
+	trace.SetPanicPolicy(trace.PanicSwallow)
+	defer trace.SetPanicPolicy(trace.PanicPropagate)
+
+	h := &recordRecorder{}
+	SiteTestPanic.Install(trace.AdaptRecordHandler(h))
+	defer SiteTestPanic.Uninstall()
+
+	func() {
+		defer SiteTestPanic.Trace().Recover()
+		deref(trace.MaxStackDepth, nil)
+	}()
+
+	// The stack begins at the function that panicked, without the frames of
+	// the runtime, and is not limited to MaxStackDepth.
+	require.Len(t, h.records, 1)
+	var frames []trace.Frame
+	h.records[0].Attrs(func(a trace.Attr) bool {
+		if a.Kind() == trace.StackKind {
+			frames = a.Frames()
+		}
+		return true
+	})
+	require.Greater(t, len(frames), trace.MaxStackDepth)
+	require.Equal(t, "github.com/dzrw/trace_test.deref", frames[0].Function)
+}
+
+func TestRecoverSource(t *testing.T) {
+	trace.SetPanicPolicy(trace.PanicSwallow)
+	defer trace.SetPanicPolicy(trace.PanicPropagate)
+
+	h := &recordRecorder{}
+	SiteTestPanic.Install(trace.AdaptRecordHandler(h))
+	defer SiteTestPanic.Uninstall()
+
+	for _, fn := range []func(){
+		func() { panic("boom") },
+		func() { deref(0, nil) },
+	} {
+		func() {
+			defer SiteTestPanic.Trace().Recover()
+			fn()
+		}()
+	}
+
+	// The source of the event is the function that panicked, and the stack
+	// of the PanicError is the one of the event.
+	require.Len(t, h.records, 2)
+	for i, fn := range []string{"github.com/dzrw/trace_test.TestRecoverSource.func1", "github.com/dzrw/trace_test.deref"} {
+		r := h.records[i]
+		f, _ := runtime.CallersFrames([]uintptr{r.PC}).Next()
+		require.Equal(t, fn, f.Function)
+
+		var pe *trace.PanicError
+		r.Attrs(func(a trace.Attr) bool {
+			if a.Kind() == trace.StackKind {
+				require.Equal(t, fn, a.Frames()[0].Function)
+			}
+			if err, ok := a.Value().(error); ok {
+				require.ErrorAs(t, err, &pe)
+			}
+			return true
+		})
+		require.NotNil(t, pe)
+		require.True(t, strings.HasPrefix(string(pe.Stack), fn+"\n\t"), string(pe.Stack))
+	}
+}
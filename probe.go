@@ -1,21 +1,242 @@
 package trace
 
 import (
+	"context"
 	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
+/*
+A Probe is a named instrumentation point that is disarmed until an operator
+arms it at run time with ArmProbe. Firing a disarmed probe costs a single
+atomic load, so probes can be left in hot paths, e.g.
+
+	var ProbeCacheMiss = trace.NewProbe("cache.miss")
+
+	func get(key string) {
+		...
+		ProbeCacheMiss.Fire(trace.String("key", key))
+	}
+
+An armed probe logs each hit as an event, whose text is the probe's name, to
+the Handler it was armed with. Each hit is a trace of the probe's Site, whose
+ID counts the hits; handlers are not notified that these traces are created
+or finished. Define the probe's Site in the registry of the Handler, like any
+other site; handlers drop the events of sites that they cannot identify.
+
+The Site of a probe is not among Sites, so that EnableSites, Shutdown and the
+handlers installed into every site leave the probe to ArmProbe and
+DisarmProbe.
+*/
 type Probe interface {
-	fmt.Stringer
+	fmt.Stringer // String returns the probe's name.
+
+	// Site returns the Tracepoint through which the probe reports.
+	Site() Tracepoint
+
+	// Armed reports whether the probe is armed.
+	Armed() bool
+
+	// Fire reports a hit with attrs if the probe is armed and its condition
+	// holds.
+	Fire(attrs ...Attr)
+}
+
+// ProbeOptions describe how a probe reports once armed.
+type ProbeOptions struct {
+	Handler Handler // Handler receives the probe's events.
+	Level   Level   // Level is the level of the events, or InfoLevel if zero.
+
+	// Match is the condition of the probe: a hit is reported only if, for
+	// each attr in Match, the fired attrs include an attr with the same key
+	// and formatted value. If Match is empty, every hit is reported.
+	Match []Attr
+
+	// Limit is the number of hits after which the probe disarms itself, or
+	// zero for no limit.
+	Limit int
+}
+
+// NewProbe creates a disarmed Probe. Probes that share a name are armed and
+// disarmed together.
+func NewProbe(name string) Probe {
+	p := &probe{
+		name: name,
+		tp:   newTracepoint(),
+	}
+	probes.add(p)
+	return p
+}
+
+// Probes returns every Probe created by NewProbe, sorted by name.
+func Probes() []Probe {
+	all := probes.all()
+	arr := make([]Probe, len(all))
+	for i, p := range all {
+		arr[i] = p
+	}
+	return arr
+}
+
+// ArmProbe arms the probes named name with opts. A probe that is already
+// armed is rearmed. It returns an error if no probe has that name or if
+// opts.Handler is nil.
+func ArmProbe(name string, opts ProbeOptions) error {
+	if opts.Handler == nil {
+		return fmt.Errorf("trace: probe %q: no handler", name)
+	}
+	if opts.Level == 0 {
+		opts.Level = InfoLevel
+	}
+
+	arr := probes.named(name)
+	if len(arr) == 0 {
+		return fmt.Errorf("trace: no probe %q", name)
+	}
+	for _, p := range arr {
+		p.arm(opts)
+	}
+	return nil
+}
+
+// DisarmProbe disarms the probes named name. It reports whether any probe has
+// that name.
+func DisarmProbe(name string) bool {
+	arr := probes.named(name)
+	for _, p := range arr {
+		p.disarm(nil)
+	}
+	return len(arr) > 0
+}
+
+// probes holds every Probe created by NewProbe.
+var probes probeset
+
+type probeset struct {
+	mu sync.Mutex
+	m  map[string][]*probe
+}
+
+func (s *probeset) add(p *probe) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.m == nil {
+		s.m = make(map[string][]*probe)
+	}
+	s.m[p.name] = append(s.m[p.name], p)
 }
 
-func NewProbe(text string) Probe {
-	return &probe{text}
+func (s *probeset) named(name string) []*probe {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*probe(nil), s.m[name]...)
+}
+
+func (s *probeset) all() []*probe {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var arr []*probe
+	for _, v := range s.m {
+		arr = append(arr, v...)
+	}
+	sort.SliceStable(arr, func(i, j int) bool { return arr[i].name < arr[j].name })
+	return arr
 }
 
 type probe struct {
-	text string
+	name  string
+	tp    *tracepoint
+	armed atomic.Pointer[probearm]
+	mu    sync.Mutex // serializes arming and disarming
+}
+
+// probearm is the state of an armed probe.
+type probearm struct {
+	opts      ProbeOptions
+	remaining int64 // accessed atomically; hits left if opts.Limit > 0
 }
 
 func (p *probe) String() string {
-	return p.text
+	return p.name
+}
+
+func (p *probe) Site() Tracepoint {
+	return p.tp
+}
+
+func (p *probe) Armed() bool {
+	return p.armed.Load() != nil
+}
+
+func (p *probe) Fire(attrs ...Attr) {
+	a := p.armed.Load()
+	if a == nil {
+		return
+	}
+	if !matchAttrs(a.opts.Match, attrs) {
+		return
+	}
+
+	last := false
+	if a.opts.Limit > 0 {
+		n := atomic.AddInt64(&a.remaining, -1)
+		if n < 0 {
+			return
+		}
+		last = n == 0
+	}
+
+	tr := &traceimpl{
+		ctx:  context.Background(),
+		tp:   p.tp,
+		id:   atomic.AddUint64(&p.tp.next, 1) - 1,
+		then: time.Now(),
+		st:   &tracestate{},
+	}
+	p.tp.log(tr.ctx, tr, 2, a.opts.Level, p.name, attrs)
+
+	if last {
+		p.disarm(a)
+	}
+}
+
+func (p *probe) arm(opts ProbeOptions) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.tp.Install(opts.Handler)
+	p.armed.Store(&probearm{opts: opts, remaining: int64(opts.Limit)})
+}
+
+// disarm disarms the probe if it is armed with a, or unconditionally if a is
+// nil.
+func (p *probe) disarm(a *probearm) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if cur := p.armed.Load(); cur != nil && (a == nil || cur == a) {
+		p.armed.Store(nil)
+		p.tp.Uninstall()
+	}
+}
+
+// matchAttrs reports whether attrs satisfy the condition match.
+func matchAttrs(match, attrs []Attr) bool {
+	for _, m := range match {
+		key, value := m.Format()
+		found := false
+		for _, a := range attrs {
+			if a.Key() == key {
+				if _, v := a.Format(); v == value {
+					found = true
+					break
+				}
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
 }
@@ -0,0 +1,87 @@
+package trace_test
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+
+	"github.com/dzrw/trace"
+	"github.com/stretchr/testify/require"
+)
+
+var ProbeTestCacheMiss = trace.NewProbe("trace_test.cache.miss")
+
+func TestProbe(t *testing.T) {
+	reg := trace.NewRegistry()
+	reg.Define(ProbeTestCacheMiss.Site(), "probe.cache.miss")
+
+	require.Equal(t, "trace_test.cache.miss", ProbeTestCacheMiss.String())
+	require.Contains(t, trace.Probes(), ProbeTestCacheMiss)
+	require.False(t, ProbeTestCacheMiss.Armed())
+	ProbeTestCacheMiss.Fire(trace.String("key", "a"))
+
+	buf := bytes.Buffer{}
+	h := trace.NewTextHandler(&buf, trace.DebugLevel, false, false, reg)
+	require.NoError(t, trace.ArmProbe("trace_test.cache.miss", trace.ProbeOptions{
+		Handler: h,
+		Match:   []trace.Attr{trace.String("tenant", "acme")},
+		Limit:   2,
+	}))
+	require.True(t, ProbeTestCacheMiss.Armed())
+
+	ProbeTestCacheMiss.Fire(trace.String("tenant", "other"), trace.String("key", "a"))
+	ProbeTestCacheMiss.Fire(trace.String("tenant", "acme"), trace.String("key", "b"))
+	ProbeTestCacheMiss.Fire(trace.String("tenant", "acme"), trace.String("key", "c"))
+	ProbeTestCacheMiss.Fire(trace.String("tenant", "acme"), trace.String("key", "d"))
+	require.False(t, ProbeTestCacheMiss.Armed())
+
+	require.Equal(t, "site=probe.cache.miss trace=0 tenant=acme key=b event=trace_test.cache.miss\n"+
+		"site=probe.cache.miss trace=1 tenant=acme key=c event=trace_test.cache.miss\n", buf.String())
+
+	require.Error(t, trace.ArmProbe("trace_test.no.such.probe", trace.ProbeOptions{Handler: h}))
+	require.Error(t, trace.ArmProbe("trace_test.cache.miss", trace.ProbeOptions{}))
+	require.False(t, trace.DisarmProbe("trace_test.no.such.probe"))
+}
+
+func TestProbeConcurrentLimit(t *testing.T) {
+	reg := trace.NewRegistry()
+	reg.Define(ProbeTestCacheMiss.Site(), "probe.cache.miss")
+
+	buf := bytes.Buffer{}
+	h := trace.NewTextHandler(&buf, trace.DebugLevel, false, false, reg)
+	require.NoError(t, trace.ArmProbe("trace_test.cache.miss", trace.ProbeOptions{Handler: h, Limit: 10}))
+	defer trace.DisarmProbe("trace_test.cache.miss")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				ProbeTestCacheMiss.Fire()
+			}
+		}()
+	}
+	wg.Wait()
+
+	require.False(t, ProbeTestCacheMiss.Armed())
+	require.Equal(t, 10, bytes.Count(buf.Bytes(), []byte("\n")))
+}
+
+func TestProbeNotASite(t *testing.T) {
+	require.NotContains(t, trace.Sites(), ProbeTestCacheMiss.Site())
+
+	reg := trace.NewRegistry()
+	reg.Define(ProbeTestCacheMiss.Site(), "probe.cache.miss")
+
+	buf := bytes.Buffer{}
+	h := trace.NewTextHandler(&buf, trace.DebugLevel, false, false, reg)
+	require.NoError(t, trace.ArmProbe("trace_test.cache.miss", trace.ProbeOptions{Handler: h}))
+	defer trace.DisarmProbe("trace_test.cache.miss")
+
+	// Selecting sites does not silence an armed probe.
+	trace.EnableSites(reg, "no.such.site")
+	defer trace.EnableSites(reg, "")
+	ProbeTestCacheMiss.Fire()
+	require.Contains(t, buf.String(), "site=probe.cache.miss")
+}
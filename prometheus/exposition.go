@@ -0,0 +1,229 @@
+package prometheus
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/dzrw/trace"
+)
+
+// Format is an exposition format understood by Prometheus.
+type Format int
+
+const (
+	FormatText        Format = iota // FormatText is the Prometheus text format, version 0.0.4.
+	FormatOpenMetrics               // FormatOpenMetrics is the OpenMetrics text format, version 1.0.0.
+)
+
+// ContentType returns the media type of the format.
+func (f Format) ContentType() string {
+	switch f {
+	case FormatOpenMetrics:
+		return "application/openmetrics-text; version=1.0.0; charset=utf-8"
+	default:
+		return "text/plain; version=0.0.4; charset=utf-8"
+	}
+}
+
+// Negotiate returns the format that best satisfies an HTTP Accept header.
+func Negotiate(accept string) Format {
+	if strings.Contains(accept, "application/openmetrics-text") {
+		return FormatOpenMetrics
+	}
+	return FormatText
+}
+
+// ServeHTTP writes the current metrics in the format requested by the
+// client's Accept header.
+func (h *PrometheusHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f := Negotiate(r.Header.Get("Accept"))
+	w.Header().Set("Content-Type", f.ContentType())
+	if err := h.Write(w, f); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// Write writes the current metrics to w in the format f. Tracepoints that are
+// not defined in the handler's registry are omitted.
+func (h *PrometheusHandler) Write(w io.Writer, f Format) error {
+	bw := bufio.NewWriter(w)
+	for _, fam := range h.families() {
+		fam.write(bw, f)
+	}
+	if f == FormatOpenMetrics {
+		bw.WriteString("# EOF\n")
+	}
+	return bw.Flush()
+}
+
+type family struct {
+	id      string // the identifier of the site
+	name    string
+	typ     string
+	unit    string
+	help    string
+	samples []sample
+}
+
+type sample struct {
+	suffix string
+	le     string
+	value  float64
+}
+
+func (fam *family) write(w *bufio.Writer, f Format) {
+	name := fam.name
+	if f == FormatText && fam.typ == "counter" {
+		name += "_total"
+	}
+	w.WriteString("# TYPE " + name + " " + fam.typ + "\n")
+	if f == FormatOpenMetrics && fam.unit != "" {
+		w.WriteString("# UNIT " + name + " " + fam.unit + "\n")
+	}
+	if fam.help != "" {
+		w.WriteString("# HELP " + name + " " + escapeHelp(fam.help) + "\n")
+	}
+	for _, s := range fam.samples {
+		w.WriteString(fam.name + s.suffix)
+		if s.le != "" {
+			w.WriteString(`{le="` + s.le + `"}`)
+		}
+		w.WriteString(" " + formatFloat(s.value) + "\n")
+	}
+}
+
+func (h *PrometheusHandler) families() []*family {
+	h.mu.Lock()
+	arr := make([]*family, 0, len(h.sites))
+	for tp, s := range h.sites {
+		id, ok := h.reg.IdentifierFor(tp)
+		if !ok {
+			continue
+		}
+		md, _ := h.reg.MetadataFor(tp)
+		base := metricName(id, md.Unit)
+		unit := sanitize(md.Unit)
+
+		if s.hasCount && s.negative {
+			arr = append(arr, &family{
+				id: id, name: base, typ: "gauge", unit: unit, help: md.Help,
+				samples: []sample{{value: float64(s.count)}},
+			})
+		} else if s.hasCount {
+			arr = append(arr, &family{
+				id: id, name: base, typ: "counter", unit: unit, help: md.Help,
+				samples: []sample{{suffix: "_total", value: float64(s.count)}},
+			})
+		}
+		if s.errors > 0 {
+			arr = append(arr, &family{
+				id: id, name: metricName(id, "errors"), typ: "counter", help: "Failed traces of " + id + ".",
+				samples: []sample{{suffix: "_total", value: float64(s.errors)}},
+			})
+		}
+		if s.gauge.N > 0 {
+			arr = append(arr, &family{
+				id: id, name: metricName(id+"_gauge", md.Unit), typ: "gauge", unit: unit, help: md.Help,
+				samples: []sample{{value: float64(s.gauge.Last)}},
+			})
+		}
+		if s.duration.N > 0 {
+			arr = append(arr, &family{
+				id: id, name: metricName(id, "duration_seconds"), typ: "summary", unit: "seconds", help: md.Help,
+				samples: []sample{
+					{suffix: "_sum", value: s.duration.Sum.Seconds()},
+					{suffix: "_count", value: float64(s.duration.N)},
+				},
+			})
+		}
+		if s.histogram.n > 0 {
+			samples := make([]sample, 0, len(h.buckets)+3)
+			for i, le := range h.buckets {
+				samples = append(samples, sample{suffix: "_bucket", le: formatFloat(le), value: float64(s.histogram.counts[i])})
+			}
+			samples = append(samples,
+				sample{suffix: "_bucket", le: "+Inf", value: float64(s.histogram.n)},
+				sample{suffix: "_sum", value: s.histogram.sum},
+				sample{suffix: "_count", value: float64(s.histogram.n)},
+			)
+			arr = append(arr, &family{
+				id: id, name: metricName(id+"_histogram", md.Unit), typ: "histogram", unit: unit, help: md.Help,
+				samples: samples,
+			})
+		}
+	}
+	h.mu.Unlock()
+
+	sort.Slice(arr, func(i, j int) bool {
+		if arr[i].name != arr[j].name {
+			return arr[i].name < arr[j].name
+		}
+		return arr[i].id < arr[j].id
+	})
+
+	// A family whose name is taken by a site that sorts first is dropped.
+	uniq := arr[:0]
+	for _, fam := range arr {
+		if n := len(uniq); n > 0 && uniq[n-1].name == fam.name {
+			trace.ReportError(h, "Write", fmt.Errorf("prometheus: sites %q and %q are both named %s", uniq[n-1].id, fam.id, fam.name))
+			continue
+		}
+		uniq = append(uniq, fam)
+	}
+	return uniq
+}
+
+// metricName derives a Prometheus metric name from a registry identifier,
+// appending the unit as a suffix if the name does not already end with it.
+func metricName(id, unit string) string {
+	name := sanitize(id)
+	if unit = sanitize(unit); unit != "" && !strings.HasSuffix(name, "_"+unit) {
+		name += "_" + unit
+	}
+	return name
+}
+
+// sanitize replaces the characters that are not allowed in a metric name with
+// underscores.
+func sanitize(s string) string {
+	if s == "" {
+		return ""
+	}
+	sb := strings.Builder{}
+	for i, r := range s {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r == '_':
+			sb.WriteRune(r)
+		case r >= '0' && r <= '9':
+			if i == 0 {
+				sb.WriteRune('_')
+			}
+			sb.WriteRune(r)
+		default:
+			sb.WriteRune('_')
+		}
+	}
+	return sb.String()
+}
+
+func escapeHelp(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	return strings.ReplaceAll(s, "\n", `\n`)
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, +1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	default:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
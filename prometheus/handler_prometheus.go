@@ -0,0 +1,178 @@
+package prometheus
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/dzrw/trace"
+)
+
+var _ = trace.Handler(&PrometheusHandler{})
+
+// DefaultBuckets are the histogram bucket upper bounds used when none are
+// given to New.
+var DefaultBuckets = []float64{1, 2, 5, 10, 20, 50, 100, 200, 500, 1000, 2000, 5000, 10000}
+
+// PrometheusHandler is a Handler that keeps the metrics captured by each
+// tracepoint in memory so that they can be scraped by Prometheus. It does not
+// accept events.
+type PrometheusHandler struct {
+	reg     trace.Registry
+	buckets []float64
+
+	mu    sync.Mutex
+	sites map[trace.Tracepoint]*series
+}
+
+type series struct {
+	count     int64
+	hasCount  bool
+	negative  bool // a negative delta was counted, so count is a gauge
+	errors    int64
+	gauge     trace.GaugeSummary
+	duration  trace.DurationSummary
+	histogram histogram
+}
+
+type histogram struct {
+	n      uint64
+	sum    float64
+	counts []uint64 // counts[i] is the number of samples <= buckets[i]
+}
+
+/*
+New creates a PrometheusHandler that names metrics after the identifiers in
+reg and sorts histogram samples into buckets. If buckets is nil,
+DefaultBuckets are used.
+
+Each kind of metric of a site has its own name, followed by the unit of the
+site, so that a site may record several kinds. For a site identified as
+"db.read" with the unit "bytes":
+
+	db_read_bytes_total            counter
+	db_read_gauge_bytes            gauge
+	db_read_histogram_bytes        histogram
+	db_read_duration_seconds       summary
+	db_read_errors_total           counter of the traces that failed
+
+Once a site counts a negative delta, its count can go down, so it is exposed
+as a gauge named without the _total suffix, e.g. db_read_bytes. Sites whose
+names collide once sanitized, like "db.read" and "db_read", are exposed
+under the name of the site whose identifier sorts first; the collision is
+reported with trace.ReportError.
+*/
+func New(reg trace.Registry, buckets []float64) *PrometheusHandler {
+	if reg == nil {
+		reg = trace.NewRegistry()
+	}
+	if buckets == nil {
+		buckets = DefaultBuckets
+	}
+	b := append([]float64(nil), buckets...)
+	sort.Float64s(b)
+	return &PrometheusHandler{
+		reg:     reg,
+		buckets: b,
+		sites:   make(map[trace.Tracepoint]*series),
+	}
+}
+
+func (h *PrometheusHandler) Flags() trace.HandlerFlags {
+	return 0
+}
+
+func (h *PrometheusHandler) Enabled(l trace.Level) bool {
+	return false
+}
+
+func (h *PrometheusHandler) TraceCreated(tr trace.Trace, attrs []trace.Attr) {}
+
+func (h *PrometheusHandler) TraceFinished(tr trace.Trace, attrs []trace.Attr) {
+	if tr.Status().Failed() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		h.series(tr.Site()).errors++
+	}
+}
+
+func (h *PrometheusHandler) Log(tr trace.Trace, l trace.Level, attrs ...[]trace.Attr) error {
+	return nil
+}
+
+func (h *PrometheusHandler) Count(tp trace.Tracepoint, delta int64) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s := h.series(tp)
+	s.count += delta
+	s.hasCount = true
+	s.negative = s.negative || delta < 0
+	return nil
+}
+
+func (h *PrometheusHandler) Gauge(tp trace.Tracepoint, value int64) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s := h.series(tp)
+	s.gauge.Last = value
+	s.gauge.N++
+	return nil
+}
+
+func (h *PrometheusHandler) Duration(tp trace.Tracepoint, d time.Duration) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s := h.series(tp)
+	s.duration.Sum += d
+	s.duration.N++
+	return nil
+}
+
+func (h *PrometheusHandler) Histogram(tp trace.Tracepoint, sample int64) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	hist := &h.series(tp).histogram
+	if hist.counts == nil {
+		hist.counts = make([]uint64, len(h.buckets))
+	}
+	v := float64(sample)
+	for i, le := range h.buckets {
+		if v <= le {
+			hist.counts[i]++
+		}
+	}
+	hist.sum += v
+	hist.n++
+	return nil
+}
+
+// Aggregate merges metrics that were accumulated by a trace.Aggregator.
+func (h *PrometheusHandler) Aggregate(arr []trace.Metrics) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, m := range arr {
+		s := h.series(m.Site)
+		if m.Count != 0 {
+			s.count += m.Count
+			s.hasCount = true
+			s.negative = s.negative || m.Count < 0
+		}
+		if m.Gauge.N > 0 {
+			s.gauge.Last = m.Gauge.Last
+			s.gauge.N += m.Gauge.N
+		}
+		s.duration.Sum += m.Duration.Sum
+		s.duration.N += m.Duration.N
+		s.errors += m.Errors
+	}
+	return nil
+}
+
+func (h *PrometheusHandler) series(tp trace.Tracepoint) *series {
+	if s, ok := h.sites[tp]; ok {
+		return s
+	}
+	s := &series{}
+	h.sites[tp] = s
+	return s
+}
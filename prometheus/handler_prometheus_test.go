@@ -0,0 +1,130 @@
+package prometheus_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dzrw/trace"
+	"github.com/dzrw/trace/prometheus"
+	"github.com/stretchr/testify/require"
+)
+
+var SiteTestHandler = trace.Site()
+
+func TestPrometheusHandler(t *testing.T) {
+	reg := trace.NewRegistry()
+	reg.Define(SiteTestHandler, "prometheus_test.SiteTestHandler")
+	reg.Describe(SiteTestHandler, trace.Metadata{Help: "Bytes read.", Unit: "bytes"})
+
+	h := prometheus.New(reg, []float64{10, 100})
+	SiteTestHandler.Install(h)
+	defer SiteTestHandler.Uninstall()
+
+	SiteTestHandler.Count(2)
+	SiteTestHandler.Count(3)
+	SiteTestHandler.Duration(1500 * time.Millisecond)
+	SiteTestHandler.Gauge(7)
+	SiteTestHandler.Histogram(50)
+
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	body := get(t, srv.URL, "")
+	require.Contains(t, body, "# TYPE prometheus_test_SiteTestHandler_bytes_total counter\n")
+	require.Contains(t, body, "# HELP prometheus_test_SiteTestHandler_bytes_total Bytes read.\n")
+	require.Contains(t, body, "prometheus_test_SiteTestHandler_bytes_total 5\n")
+	require.Contains(t, body, "prometheus_test_SiteTestHandler_duration_seconds_sum 1.5\n")
+	require.Contains(t, body, `prometheus_test_SiteTestHandler_histogram_bytes_bucket{le="10"} 0`+"\n")
+	require.Contains(t, body, `prometheus_test_SiteTestHandler_histogram_bytes_bucket{le="100"} 1`+"\n")
+	require.Contains(t, body, `prometheus_test_SiteTestHandler_histogram_bytes_bucket{le="+Inf"} 1`+"\n")
+	require.NotContains(t, body, "# EOF")
+
+	body = get(t, srv.URL, "application/openmetrics-text; version=1.0.0")
+	require.Contains(t, body, "# TYPE prometheus_test_SiteTestHandler_bytes counter\n")
+	require.Contains(t, body, "# UNIT prometheus_test_SiteTestHandler_bytes bytes\n")
+	require.Contains(t, body, "prometheus_test_SiteTestHandler_bytes_total 5\n")
+	require.True(t, strings.HasSuffix(body, "# EOF\n"))
+
+	// A site that records every kind of metric has one family per kind.
+	for _, f := range []prometheus.Format{prometheus.FormatText, prometheus.FormatOpenMetrics} {
+		buf := strings.Builder{}
+		require.NoError(t, h.Write(&buf, f))
+		names := map[string]bool{}
+		for _, line := range strings.Split(buf.String(), "\n") {
+			if strings.HasPrefix(line, "# TYPE ") {
+				name := strings.Fields(line)[2]
+				require.False(t, names[name], "duplicate family %s", name)
+				names[name] = true
+			}
+		}
+		require.Len(t, names, 4)
+	}
+	require.Contains(t, body, "# TYPE prometheus_test_SiteTestHandler_gauge_bytes gauge\n")
+	require.Contains(t, body, "prometheus_test_SiteTestHandler_gauge_bytes 7\n")
+	require.Contains(t, body, "# TYPE prometheus_test_SiteTestHandler_histogram_bytes histogram\n")
+}
+
+func get(t *testing.T, url, accept string) string {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	require.NoError(t, err)
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	sb := strings.Builder{}
+	_, err = io.Copy(&sb, resp.Body)
+	require.NoError(t, err)
+	return sb.String()
+}
+
+var (
+	SiteTestNegative  = trace.Site()
+	SiteTestCollision = trace.Site()
+	SiteTestFailed    = trace.Site()
+)
+
+func TestPrometheusHandlerNames(t *testing.T) {
+	var errs []string
+	trace.SetErrorHandler(trace.ErrorHandlerFunc(func(h trace.Handler, op string, err error) {
+		errs = append(errs, op+": "+err.Error())
+	}))
+	defer trace.SetErrorHandler(nil)
+
+	reg := trace.NewRegistry()
+	reg.Define(SiteTestNegative, "queue.depth")
+	reg.Define(SiteTestCollision, "queue_depth")
+	reg.Define(SiteTestFailed, "job")
+	reg.Describe(SiteTestFailed, trace.Metadata{Help: "Jobs run."})
+
+	h := prometheus.New(reg, nil)
+	for _, tp := range []trace.Tracepoint{SiteTestNegative, SiteTestCollision, SiteTestFailed} {
+		tp.Install(h)
+		defer tp.Uninstall()
+	}
+
+	// A count that goes down is a gauge.
+	SiteTestNegative.Count(3)
+	SiteTestNegative.Count(-1)
+	SiteTestCollision.Count(5)
+	SiteTestFailed.Count(1)
+	SiteTestFailed.Trace().CloseWithError(io.EOF)
+
+	buf := strings.Builder{}
+	require.NoError(t, h.Write(&buf, prometheus.FormatText))
+	body := buf.String()
+	require.Contains(t, body, "# TYPE queue_depth gauge\nqueue_depth 2\n")
+	require.NotContains(t, body, "queue_depth_total")
+	require.Equal(t, 1, strings.Count(body, "# TYPE queue_depth "))
+	require.Equal(t, []string{`Write: prometheus: sites "queue.depth" and "queue_depth" are both named queue_depth`}, errs)
+
+	require.Contains(t, body, "# HELP job_total Jobs run.\n")
+	require.Contains(t, body, "# HELP job_errors_total Failed traces of job.\n")
+	require.Contains(t, body, "job_errors_total 1\n")
+}
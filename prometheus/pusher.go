@@ -0,0 +1,140 @@
+package prometheus
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dzrw/trace"
+)
+
+/*
+Pusher periodically pushes the metrics held by a PrometheusHandler to a
+Pushgateway. It is intended for batch jobs that exit before they could be
+scraped.
+
+A Pusher is not a Handler, so trace.Shutdown does not close it. Close is
+required to push the final metrics before the process exits, e.g.
+
+	p := prometheus.NewPusher(h, gateway, "job", "", false, time.Minute)
+	defer p.Close()
+	defer trace.Shutdown(ctx)
+
+so that the handlers are drained before the last push.
+*/
+type Pusher struct {
+	h       *PrometheusHandler
+	url     string
+	method  string
+	client  *http.Client
+	timeout time.Duration
+
+	stop chan struct{}
+	done chan struct{}
+	once sync.Once
+}
+
+// NewPusher creates a Pusher that pushes the metrics held by h to the
+// Pushgateway at gateway, grouped by the job and instance labels. If
+// instance is empty, the grouping key is the job alone. If replace is true,
+// each push replaces all metrics in the group (PUT); otherwise, it replaces
+// only the metrics with the same names (POST). If interval is positive,
+// metrics are pushed every interval; otherwise, they are only pushed by calls
+// to Push and Close.
+func NewPusher(h *PrometheusHandler, gateway, job, instance string, replace bool, interval time.Duration) *Pusher {
+	u := strings.TrimSuffix(gateway, "/") + "/metrics" + groupingPath("job", job)
+	if instance != "" {
+		u += groupingPath("instance", instance)
+	}
+
+	method := http.MethodPost
+	if replace {
+		method = http.MethodPut
+	}
+
+	p := &Pusher{
+		h:       h,
+		url:     u,
+		method:  method,
+		client:  http.DefaultClient,
+		timeout: 10 * time.Second,
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	if interval > 0 {
+		go p.run(interval)
+	} else {
+		close(p.done)
+	}
+	return p
+}
+
+// groupingPath encodes a grouping label as a Pushgateway URL path segment.
+// Values that contain a slash are base64-encoded, as the Pushgateway requires,
+// and an empty value is written as "=", its base64 encoding with padding.
+func groupingPath(name, value string) string {
+	if value == "" {
+		return "/" + name + "@base64/="
+	}
+	if strings.Contains(value, "/") {
+		return "/" + name + "@base64/" + base64.RawURLEncoding.EncodeToString([]byte(value))
+	}
+	return "/" + name + "/" + url.PathEscape(value)
+}
+
+func (p *Pusher) run(interval time.Duration) {
+	defer close(p.done)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			trace.ReportError(p.h, "Push", p.Push())
+		case <-p.stop:
+			return
+		}
+	}
+}
+
+// Push sends the current metrics to the Pushgateway.
+func (p *Pusher) Push() error {
+	buf := bytes.Buffer{}
+	if err := p.h.Write(&buf, FormatText); err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(p.method, p.url, &buf)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", FormatText.ContentType())
+
+	client := *p.client
+	client.Timeout = p.timeout
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("pushgateway: %s %s: %s: %s", p.method, p.url, resp.Status, bytes.TrimSpace(body))
+	}
+	return nil
+}
+
+// Close stops the periodic push and pushes the metrics one last time.
+func (p *Pusher) Close() error {
+	p.once.Do(func() { close(p.stop) })
+	<-p.done
+	return p.Push()
+}
@@ -0,0 +1,72 @@
+package prometheus_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/dzrw/trace"
+	"github.com/dzrw/trace/prometheus"
+	"github.com/stretchr/testify/require"
+)
+
+var SiteTestPusher = trace.Site()
+
+func TestPusher(t *testing.T) {
+	reg := trace.NewRegistry()
+	reg.Define(SiteTestPusher, "prometheus_test.SiteTestPusher")
+
+	h := prometheus.New(reg, nil)
+	SiteTestPusher.Install(h)
+	defer SiteTestPusher.Uninstall()
+
+	var (
+		mu     sync.Mutex
+		method string
+		path   string
+		body   string
+	)
+	gw := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		method, path, body = r.Method, r.URL.EscapedPath(), string(b)
+		mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer gw.Close()
+
+	p := prometheus.NewPusher(h, gw.URL, "nightly", "host/1", true, 0)
+	SiteTestPusher.Count(42)
+	require.NoError(t, p.Close())
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Equal(t, http.MethodPut, method)
+	require.Equal(t, "/metrics/job/nightly/instance@base64/aG9zdC8x", path)
+	require.Contains(t, body, "prometheus_test_SiteTestPusher_total 42\n")
+}
+
+func TestPusherEmptyJob(t *testing.T) {
+	var path string
+	gw := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.EscapedPath()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer gw.Close()
+
+	p := prometheus.NewPusher(prometheus.New(nil, nil), gw.URL, "", "", false, 0)
+	require.NoError(t, p.Push())
+	require.Equal(t, "/metrics/job@base64/=", path)
+}
+
+func TestPusherError(t *testing.T) {
+	gw := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "nope", http.StatusBadRequest)
+	}))
+	defer gw.Close()
+
+	p := prometheus.NewPusher(prometheus.New(nil, nil), gw.URL, "nightly", "", false, 0)
+	require.ErrorContains(t, p.Push(), "400 Bad Request: nope")
+}
@@ -0,0 +1,60 @@
+package trace
+
+import "time"
+
+// A Record holds information about an event captured by a trace.
+type Record struct {
+	Time    time.Time  // Time is when the event was captured.
+	Level   Level      // Level is the severity of the event.
+	Message string     // Message is the event's text, or empty for Trace.Log.
+	Site    Tracepoint // Site is the tracepoint that originated the trace.
+	Trace   Trace      // Trace is the trace that captured the event.
+	PC      uintptr    // PC is the program counter of the call site, or zero.
+
+	front []Attr // the attrs of the trace
+	attrs []Attr // the attrs of the event
+}
+
+// NewRecord creates a Record from the given arguments. Use AddAttrs to add
+// attrs to the Record.
+func NewRecord(t time.Time, level Level, msg string, pc uintptr) Record {
+	return Record{
+		Time:    t,
+		Level:   level,
+		Message: msg,
+		PC:      pc,
+	}
+}
+
+// Attrs calls f on each Attr in the Record, beginning with the attrs of the
+// trace, if any. Iteration stops if f returns false.
+func (r Record) Attrs(f func(Attr) bool) {
+	for _, a := range r.front {
+		if !f(a) {
+			return
+		}
+	}
+	for _, a := range r.attrs {
+		if !f(a) {
+			return
+		}
+	}
+}
+
+// NumAttrs returns the number of attrs in the Record.
+func (r Record) NumAttrs() int {
+	return len(r.front) + len(r.attrs)
+}
+
+// AddAttrs appends attrs to the Record's attrs. It never modifies the attrs
+// of copies of the Record.
+func (r *Record) AddAttrs(attrs ...Attr) {
+	r.attrs = append(r.attrs[:len(r.attrs):len(r.attrs)], attrs...)
+}
+
+// Source returns the function, file and line of the call site. If PC is zero,
+// it returns empty values.
+func (r Record) Source() (function, file string, line int) {
+	f := resolvePC(r.PC)
+	return f.Function, f.File, f.Line
+}
@@ -0,0 +1,77 @@
+package trace_test
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dzrw/trace"
+	"github.com/stretchr/testify/require"
+)
+
+var SiteTestRecord = trace.Site()
+
+type recordRecorder struct {
+	records []trace.Record
+}
+
+func (h *recordRecorder) Flags() trace.HandlerFlags                      { return trace.FlagSourceInfo }
+func (h *recordRecorder) Enabled(trace.Level) bool                       { return true }
+func (h *recordRecorder) Count(trace.Tracepoint, int64) error            { return nil }
+func (h *recordRecorder) Gauge(trace.Tracepoint, int64) error            { return nil }
+func (h *recordRecorder) Duration(trace.Tracepoint, time.Duration) error { return nil }
+func (h *recordRecorder) Histogram(trace.Tracepoint, int64) error        { return nil }
+func (h *recordRecorder) TraceCreated(trace.Trace, []trace.Attr)         {}
+func (h *recordRecorder) TraceFinished(trace.Trace, []trace.Attr)        {}
+
+func (h *recordRecorder) Handle(r trace.Record) error {
+	h.records = append(h.records, r)
+	return nil
+}
+
+func TestRecordAttrs(t *testing.T) {
+	r := trace.NewRecord(time.Now(), trace.InfoLevel, "hello", 0)
+	r.AddAttrs(trace.Int("a", 1), trace.Int("b", 2))
+	require.Equal(t, 2, r.NumAttrs())
+
+	c := r
+	c.AddAttrs(trace.Int("c", 3))
+	r.AddAttrs(trace.Int("d", 4))
+
+	var keys []string
+	c.Attrs(func(a trace.Attr) bool {
+		keys = append(keys, a.Key())
+		return true
+	})
+	require.Equal(t, []string{"a", "b", "c"}, keys)
+
+	keys = nil
+	r.Attrs(func(a trace.Attr) bool {
+		keys = append(keys, a.Key())
+		return a.Key() != "b"
+	})
+	require.Equal(t, []string{"a", "b"}, keys)
+}
+
+func TestRecordHandler(t *testing.T) {
+	h := &recordRecorder{}
+	SiteTestRecord.Install(trace.AdaptRecordHandler(h))
+	defer SiteTestRecord.Uninstall()
+
+	before := time.Now()
+	tr := SiteTestRecord.Trace(trace.String("user_id", "u1"))
+	tr.Info("hello", trace.Int("n", 1))
+
+	require.Len(t, h.records, 1)
+	r := h.records[0]
+	require.Equal(t, "hello", r.Message)
+	require.Equal(t, trace.InfoLevel, r.Level)
+	require.Equal(t, SiteTestRecord, r.Site)
+	require.Equal(t, tr, r.Trace)
+	require.False(t, r.Time.Before(before))
+	require.Equal(t, 2, r.NumAttrs())
+
+	fn, file, _ := r.Source()
+	require.Equal(t, "record_test.go", filepath.Base(file))
+	require.Equal(t, "github.com/dzrw/trace_test.TestRecordHandler", fn)
+}
@@ -10,17 +10,29 @@ type Registry interface {
 	IsDefined(Tracepoint) bool
 	IdentifierFor(Tracepoint) (string, bool)
 	TracepointFor(string) (Tracepoint, bool)
+
+	Describe(Tracepoint, Metadata)
+	MetadataFor(Tracepoint) (Metadata, bool)
+}
+
+// Metadata describes a Tracepoint to the handlers that export its metrics.
+type Metadata struct {
+	Help string            // Help is a human-readable description of the tracepoint.
+	Unit string            // Unit is the unit of the tracepoint's samples, e.g. "bytes".
+	Tags map[string]string // Tags qualify the tracepoint's samples, e.g. "env": "prod".
 }
 
 type registry struct {
 	u map[Tracepoint]string
 	v map[string]Tracepoint
+	w map[Tracepoint]Metadata
 }
 
 func NewRegistry() Registry {
 	return &registry{
 		u: make(map[Tracepoint]string),
 		v: make(map[string]Tracepoint),
+		w: make(map[Tracepoint]Metadata),
 	}
 }
 
@@ -38,6 +50,7 @@ func (m *registry) Undefine(tp Tracepoint) {
 		delete(m.u, tp)
 		delete(m.v, id)
 	}
+	delete(m.w, tp)
 }
 
 func (m *registry) IsDefined(tp Tracepoint) bool {
@@ -54,3 +67,45 @@ func (m *registry) TracepointFor(id string) (tp Tracepoint, ok bool) {
 	tp, ok = m.v[id]
 	return
 }
+
+func (m *registry) Describe(tp Tracepoint, md Metadata) {
+	m.w[tp] = md
+}
+
+func (m *registry) MetadataFor(tp Tracepoint) (md Metadata, ok bool) {
+	md, ok = m.w[tp]
+	return
+}
+
+/*
+MatchIdentifier reports whether the identifier id matches pattern. In a
+pattern, '*' matches any sequence of characters, including dots and the empty
+sequence, and '?' matches any single character. Examples:
+
+	MatchIdentifier("db.*", "db.query") => true
+	MatchIdentifier("db.*", "db") => false
+	MatchIdentifier("*.health", "db.health") => true
+*/
+func MatchIdentifier(pattern, id string) bool {
+	var p, i int           // positions in pattern and id
+	var star, next = -1, 0 // position of the last '*' and where it resumes in id
+	for i < len(id) {
+		switch {
+		case p < len(pattern) && pattern[p] == '*':
+			star, next = p, i
+			p++
+		case p < len(pattern) && (pattern[p] == '?' || pattern[p] == id[i]):
+			p++
+			i++
+		case star >= 0:
+			next++
+			p, i = star+1, next
+		default:
+			return false
+		}
+	}
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
@@ -0,0 +1,108 @@
+package trace
+
+import (
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Source returns an Attr named "source" for the call site at pc, as returned
+// by runtime.Callers. The function, file and line of the call site are
+// resolved when the Attr is formatted, and cached.
+func Source(pc uintptr) Attr {
+	return Attr{key: "source", val: sourcepc(pc), kind: SourceKind}
+}
+
+// sourcepc is the value of an Attr of SourceKind.
+type sourcepc uintptr
+
+// Source returns the Attr's value as the function, file and line of a call
+// site. It panics if the value is not a call site.
+func (a Attr) Source() Frame {
+	return resolvePC(uintptr(a.val.(sourcepc)))
+}
+
+// formatSource formats f as the function followed by the file and line in
+// parentheses, like a frame formatted by formatStackTrace.
+func formatSource(f Frame) string {
+	return f.Function + "(" + f.File + ":" + strconv.Itoa(f.Line) + ")"
+}
+
+// pccache caches the frames resolved by resolvePC.
+var pccache sync.Map // map[uintptr]Frame
+
+// resolvePC returns the function, file and line of the call site at pc, or
+// the zero Frame if pc is zero. The file is trimmed if SetTrimSourcePaths is
+// on.
+func resolvePC(pc uintptr) Frame {
+	if pc == 0 {
+		return Frame{}
+	}
+
+	var f Frame
+	if v, ok := pccache.Load(pc); ok {
+		f = v.(Frame)
+	} else {
+		rf, _ := runtime.CallersFrames([]uintptr{pc}).Next()
+		f = Frame{Function: rf.Function, File: rf.File, Line: rf.Line}
+		pccache.Store(pc, f)
+	}
+
+	if TrimSourcePaths() {
+		f.File = trimSourcePath(f.Function, f.File)
+	}
+	return f
+}
+
+// trimSourcePath returns the path of file relative to the root of the
+// module that contains it, prefixed with the module path: the import path of
+// the function's package followed by the base name of the file. It returns
+// file if the function is unknown.
+func trimSourcePath(function, file string) string {
+	i := strings.LastIndexByte(file, '/')
+	if function == "" || i < 0 {
+		return file
+	}
+
+	// The package path ends at the first dot after its last slash. Type
+	// parameters may contain slashes, so they are ignored.
+	pkg := function
+	if j := strings.IndexByte(pkg, '['); j >= 0 {
+		pkg = pkg[:j]
+	}
+	slash := strings.LastIndexByte(pkg, '/')
+	if j := strings.IndexByte(pkg[slash+1:], '.'); j >= 0 {
+		pkg = pkg[:slash+1+j]
+	}
+
+	// Dots in the last element of the path are escaped, and external test
+	// packages share the directory of the package they test.
+	pkg = strings.ReplaceAll(pkg, "%2e", ".")
+	pkg = strings.TrimSuffix(pkg, "_test")
+	if pkg == "main" {
+		return file[i+1:]
+	}
+	return pkg + file[i:]
+}
+
+// trimSourcePaths holds 1 if source paths are trimmed.
+var trimSourcePaths uint32
+
+// SetTrimSourcePaths sets whether the files of call sites are written
+// relative to the root of their module, prefixed with the module path, like
+// "github.com/dzrw/trace/trace.go", rather than as absolute paths. Files of
+// package main are written as their base name. The default is off.
+func SetTrimSourcePaths(on bool) {
+	var v uint32
+	if on {
+		v = 1
+	}
+	atomic.StoreUint32(&trimSourcePaths, v)
+}
+
+// TrimSourcePaths reports whether source paths are trimmed.
+func TrimSourcePaths() bool {
+	return atomic.LoadUint32(&trimSourcePaths) == 1
+}
@@ -0,0 +1,43 @@
+package trace_test
+
+import (
+	"bytes"
+	"runtime"
+	"testing"
+
+	"github.com/dzrw/trace"
+	"github.com/stretchr/testify/require"
+)
+
+var SiteTestSource = trace.Site()
+
+func TestSource(t *testing.T) {
+	var pcs [1]uintptr
+	runtime.Callers(1, pcs[:])
+	a := trace.Source(pcs[0])
+	require.Equal(t, trace.SourceKind, a.Kind())
+
+	f := a.Source()
+	require.Equal(t, "github.com/dzrw/trace_test.TestSource", f.Function)
+	require.Contains(t, f.File, "/source_test.go")
+	require.Equal(t, 16, f.Line)
+
+	trace.SetTrimSourcePaths(true)
+	defer trace.SetTrimSourcePaths(false)
+	require.Equal(t, "github.com/dzrw/trace/source_test.go", a.Source().File)
+	_, v := a.Format()
+	require.Equal(t, "github.com/dzrw/trace_test.TestSource(github.com/dzrw/trace/source_test.go:16)", v)
+
+	reg := trace.NewRegistry()
+	reg.Define(SiteTestSource, "source")
+	buf := bytes.Buffer{}
+	SiteTestSource.Install(trace.NewTextHandler(&buf, trace.DebugLevel, true, false, reg))
+	defer SiteTestSource.Uninstall()
+
+	tr := SiteTestSource.Trace()
+	tr.Info("hello")
+	require.Equal(t, ""+
+		"site=source trace=0 event=\"trace created\" source=github.com/dzrw/trace_test.TestSource(github.com/dzrw/trace/source_test.go:37)\n"+
+		"site=source trace=0 event=hello source=github.com/dzrw/trace_test.TestSource(github.com/dzrw/trace/source_test.go:38)\n",
+		buf.String())
+}
@@ -0,0 +1,130 @@
+package trace
+
+import (
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// MaxStackDepth is the number of frames kept by CaptureStack. The stacks of
+// panics recorded by Trace.Recover are not limited.
+const MaxStackDepth = 32
+
+// A Frame is a symbolized frame of a stack trace.
+type Frame struct {
+	Function string
+	File     string
+	Line     int
+}
+
+// CaptureStack returns the stack of the calling goroutine, innermost frame
+// first. The argument skip is the number of frames to skip, with 0
+// identifying the caller of CaptureStack.
+func CaptureStack(skip int) []Frame {
+	var pcs [MaxStackDepth]uintptr
+	n := runtime.Callers(skip+2, pcs[:])
+	return symbolize(pcs[:n])
+}
+
+// capturePanicStack returns the whole stack of a panicking goroutine, from
+// the function that panicked outwards. It is called by a deferred function;
+// skip is as for CaptureStack, and the frames of the runtime's panic
+// machinery below the deferred function are dropped. Their number is
+// returned too, so that the caller can skip them itself.
+func capturePanicStack(skip int) (frames []Frame, dropped int) {
+	pcs := make([]uintptr, 2*MaxStackDepth)
+	for {
+		n := runtime.Callers(skip+2, pcs)
+		if n < len(pcs) {
+			pcs = pcs[:n]
+			break
+		}
+		pcs = make([]uintptr, 2*len(pcs))
+	}
+
+	arr := symbolize(pcs)
+	for len(arr) > 0 && strings.HasPrefix(arr[0].Function, "runtime.") {
+		arr = arr[1:]
+		dropped++
+	}
+	return arr, dropped
+}
+
+// symbolize returns the frames of the call sites in pcs.
+func symbolize(pcs []uintptr) []Frame {
+	arr := make([]Frame, 0, len(pcs))
+	frames := runtime.CallersFrames(pcs)
+	for {
+		f, more := frames.Next()
+		if f.Function != "" {
+			arr = append(arr, Frame{Function: f.Function, File: f.File, Line: f.Line})
+		}
+		if !more {
+			return arr
+		}
+	}
+}
+
+// formatFrames formats frames like the tracebacks of the runtime, as the
+// function and the file and line of each frame.
+func formatFrames(frames []Frame) string {
+	sb := strings.Builder{}
+	for _, f := range frames {
+		sb.WriteString(f.Function)
+		sb.WriteString("\n\t")
+		sb.WriteString(f.File)
+		sb.WriteByte(':')
+		sb.WriteString(strconv.Itoa(f.Line))
+		sb.WriteByte('\n')
+	}
+	return sb.String()
+}
+
+// StackTrace returns an Attr named "stack" for a stack trace.
+func StackTrace(frames []Frame) Attr {
+	return Attr{key: "stack", val: frames, kind: StackKind}
+}
+
+// Frames returns the Attr's value as a stack trace. It panics if the value is
+// not a stack trace.
+func (a Attr) Frames() []Frame {
+	return a.val.([]Frame)
+}
+
+// formatStackTrace formats frames compactly, as the function and the base
+// name of the file of each frame.
+func formatStackTrace(frames []Frame) string {
+	sb := strings.Builder{}
+	for i, f := range frames {
+		if i > 0 {
+			sb.WriteByte(' ')
+		}
+		sb.WriteString(f.Function)
+		sb.WriteByte('(')
+		sb.WriteString(filepath.Base(f.File))
+		sb.WriteByte(':')
+		sb.WriteString(strconv.Itoa(f.Line))
+		sb.WriteByte(')')
+	}
+	return sb.String()
+}
+
+// stackTraceLevel is the least severe level of the events that carry a stack
+// trace for handlers with FlagStackTrace.
+var stackTraceLevel = int32(AssertionViolatedLevel)
+
+// SetStackTraceLevel sets the least severe level of the events that carry a
+// stack trace for handlers with FlagStackTrace. Like every level in this
+// package, lower is more severe: events carry a stack trace if their level is
+// less than or equal to l. The default is AssertionViolatedLevel, which
+// includes ErrorLevel but not WarnLevel.
+func SetStackTraceLevel(l Level) {
+	atomic.StoreInt32(&stackTraceLevel, int32(l))
+}
+
+// StackTraceLevel returns the level set by SetStackTraceLevel.
+func StackTraceLevel() Level {
+	return Level(atomic.LoadInt32(&stackTraceLevel))
+}
@@ -0,0 +1,51 @@
+package trace_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/dzrw/trace"
+	"github.com/stretchr/testify/require"
+)
+
+var SiteTestStack = trace.Site()
+
+func TestStackTrace(t *testing.T) {
+	reg := trace.NewRegistry()
+	reg.Define(SiteTestStack, "stack")
+
+	buf := bytes.Buffer{}
+	SiteTestStack.Install(trace.NewTextHandlerWithFlags(&buf, trace.DebugLevel, trace.FlagStackTrace, reg))
+	defer SiteTestStack.Uninstall()
+
+	tr := SiteTestStack.Trace()
+	tr.Info("fine")
+	tr.Error("failed")
+	tr.Close()
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	require.Len(t, lines, 4)
+	require.NotContains(t, lines[1], "stack=")
+	require.Contains(t, lines[2], `stack="github.com/dzrw/trace_test.TestStackTrace(stack_test.go:25) testing.tRunner(testing.go:`)
+
+	trace.SetStackTraceLevel(trace.InfoLevel)
+	defer trace.SetStackTraceLevel(trace.AssertionViolatedLevel)
+
+	buf.Reset()
+	SiteTestStack.Install(trace.NewJSONHandlerWithFlags(&buf, trace.DebugLevel, trace.FlagStackTrace, reg))
+	tr = SiteTestStack.Trace()
+	tr.Info("fine")
+
+	lines = strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	require.Len(t, lines, 2)
+	var event struct {
+		Stack []trace.Frame `json:"stack"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(lines[1]), &event))
+	require.NotEmpty(t, event.Stack)
+	require.Equal(t, "github.com/dzrw/trace_test.TestStackTrace", event.Stack[0].Function)
+	require.Contains(t, event.Stack[0].File, "stack_test.go")
+	require.Equal(t, 39, event.Stack[0].Line)
+}
@@ -0,0 +1,247 @@
+package statsd
+
+import (
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dzrw/trace"
+)
+
+var _ = trace.Handler(&StatsdHandler{})
+var _ = trace.Flusher(&StatsdHandler{})
+var _ = trace.Closer(&StatsdHandler{})
+
+// DefaultMTU is the datagram size used when none is given to New. It fits in
+// a single Ethernet frame after IP and UDP headers.
+const DefaultMTU = 1432
+
+// StatsdHandler is a Handler that sends metrics to a StatsD or DogStatsD agent
+// over a datagram socket. Metrics are packed into datagrams of up to mtu
+// bytes, which are sent when full, every interval, and on Flush. It does not
+// accept events.
+type StatsdHandler struct {
+	reg  trace.Registry
+	conn net.Conn
+	mtu  int
+
+	mu  sync.Mutex
+	buf []byte
+
+	stop   chan struct{}
+	done   chan struct{}
+	once   sync.Once
+	closed bool
+}
+
+// New creates a StatsdHandler that sends to addr on network, which must be a
+// datagram network such as "udp" or "unixgram". Metric names and DogStatsD
+// tags are taken from reg. If mtu is not positive, DefaultMTU is used. If
+// interval is positive, partially filled datagrams are sent every interval.
+func New(network, addr string, mtu int, interval time.Duration, reg trace.Registry) (*StatsdHandler, error) {
+	conn, err := net.Dial(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	if mtu <= 0 {
+		mtu = DefaultMTU
+	}
+	if reg == nil {
+		reg = trace.NewRegistry()
+	}
+
+	h := &StatsdHandler{
+		reg:  reg,
+		conn: conn,
+		mtu:  mtu,
+		buf:  make([]byte, 0, mtu),
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	if interval > 0 {
+		go h.run(interval)
+	} else {
+		close(h.done)
+	}
+	return h, nil
+}
+
+func (h *StatsdHandler) run(interval time.Duration) {
+	defer close(h.done)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			trace.ReportError(h, "Flush", h.Flush())
+		case <-h.stop:
+			return
+		}
+	}
+}
+
+func (h *StatsdHandler) Flags() trace.HandlerFlags {
+	return 0
+}
+
+func (h *StatsdHandler) Enabled(l trace.Level) bool {
+	return false
+}
+
+func (h *StatsdHandler) TraceCreated(tr trace.Trace, attrs []trace.Attr) {}
+
+func (h *StatsdHandler) TraceFinished(tr trace.Trace, attrs []trace.Attr) {}
+
+func (h *StatsdHandler) Log(tr trace.Trace, l trace.Level, attrs ...[]trace.Attr) error {
+	return nil
+}
+
+func (h *StatsdHandler) Count(tp trace.Tracepoint, delta int64) error {
+	return h.metric(tp, strconv.FormatInt(delta, 10), "c")
+}
+
+// Gauge sets a gauge. StatsD reads a signed value as a change to the gauge
+// rather than a new value, so a negative value is preceded by "0|g" in the
+// same datagram.
+func (h *StatsdHandler) Gauge(tp trace.Tracepoint, value int64) error {
+	line, ok := h.format(tp, strconv.FormatInt(value, 10), "g")
+	if !ok {
+		return nil
+	}
+	if value < 0 {
+		zero, _ := h.format(tp, "0", "g")
+		line = zero + "\n" + line
+	}
+	return h.send(line)
+}
+
+func (h *StatsdHandler) Duration(tp trace.Tracepoint, d time.Duration) error {
+	ms := float64(d) / float64(time.Millisecond)
+	return h.metric(tp, strconv.FormatFloat(ms, 'f', -1, 64), "ms")
+}
+
+func (h *StatsdHandler) Histogram(tp trace.Tracepoint, sample int64) error {
+	return h.metric(tp, strconv.FormatInt(sample, 10), "h")
+}
+
+// metric formats a metric and sends it.
+func (h *StatsdHandler) metric(tp trace.Tracepoint, value, typ string) error {
+	if line, ok := h.format(tp, value, typ); ok {
+		return h.send(line)
+	}
+	return nil
+}
+
+// format formats a metric as "name:value|type|#tag:value,...". It returns
+// false if tp has no identifier.
+func (h *StatsdHandler) format(tp trace.Tracepoint, value, typ string) (string, bool) {
+	id, ok := h.reg.IdentifierFor(tp)
+	if !ok {
+		return "", false
+	}
+
+	sb := strings.Builder{}
+	sb.WriteString(sanitize(id))
+	sb.WriteRune(':')
+	sb.WriteString(value)
+	sb.WriteRune('|')
+	sb.WriteString(typ)
+	if md, ok := h.reg.MetadataFor(tp); ok && len(md.Tags) > 0 {
+		formatTags(&sb, md.Tags)
+	}
+	return sb.String(), true
+}
+
+// send appends lines to the pending datagram, sending the datagram first if
+// the lines would not fit.
+func (h *StatsdHandler) send(lines string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var err error
+	if len(h.buf) > 0 && len(h.buf)+1+len(lines) > h.mtu {
+		err = h.flush()
+	}
+	if len(h.buf) > 0 {
+		h.buf = append(h.buf, '\n')
+	}
+	h.buf = append(h.buf, lines...)
+	return err
+}
+
+// Flush sends the pending datagram, if any.
+func (h *StatsdHandler) Flush() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.flush()
+}
+
+func (h *StatsdHandler) flush() error {
+	if h.closed {
+		h.buf = h.buf[:0]
+		return nil
+	}
+	if len(h.buf) == 0 {
+		return nil
+	}
+	_, err := h.conn.Write(h.buf)
+	h.buf = h.buf[:0]
+	return err
+}
+
+// Close stops the periodic flush, sends the pending datagram and closes the
+// socket. Subsequent metrics are discarded.
+func (h *StatsdHandler) Close() error {
+	h.once.Do(func() { close(h.stop) })
+	<-h.done
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return nil
+	}
+	err := h.flush()
+	h.closed = true
+	if cerr := h.conn.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func formatTags(sb *strings.Builder, tags map[string]string) {
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	sb.WriteString("|#")
+	for i, k := range keys {
+		if i > 0 {
+			sb.WriteRune(',')
+		}
+		sb.WriteString(sanitize(k))
+		if v := tags[k]; v != "" {
+			sb.WriteRune(':')
+			sb.WriteString(sanitize(v))
+		}
+	}
+}
+
+// sanitize replaces the characters that delimit the StatsD line format with
+// underscores.
+func sanitize(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case ':', '|', '@', '#', ',', '\n', ' ', '\t':
+			return '_'
+		default:
+			return r
+		}
+	}, s)
+}
@@ -0,0 +1,72 @@
+package statsd_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/dzrw/trace"
+	"github.com/dzrw/trace/statsd"
+	"github.com/stretchr/testify/require"
+)
+
+var SiteTestHandler = trace.Site()
+
+func TestStatsdHandler(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer pc.Close()
+
+	reg := trace.NewRegistry()
+	reg.Define(SiteTestHandler, "statsd_test.SiteTestHandler")
+	reg.Describe(SiteTestHandler, trace.Metadata{Tags: map[string]string{"env": "test", "canary": ""}})
+
+	h, err := statsd.New("udp", pc.LocalAddr().String(), 0, 0, reg)
+	require.NoError(t, err)
+	defer h.Close()
+
+	SiteTestHandler.Install(h)
+	defer SiteTestHandler.Uninstall()
+
+	SiteTestHandler.Count(1)
+	SiteTestHandler.Gauge(-3)
+	SiteTestHandler.Duration(1500 * time.Microsecond)
+	SiteTestHandler.Histogram(7)
+	require.NoError(t, h.Flush())
+
+	expected := "statsd_test.SiteTestHandler:1|c|#canary,env:test\n" +
+		"statsd_test.SiteTestHandler:0|g|#canary,env:test\n" +
+		"statsd_test.SiteTestHandler:-3|g|#canary,env:test\n" +
+		"statsd_test.SiteTestHandler:1.5|ms|#canary,env:test\n" +
+		"statsd_test.SiteTestHandler:7|h|#canary,env:test"
+	require.Equal(t, expected, read(t, pc))
+}
+
+func TestStatsdHandlerMTU(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer pc.Close()
+
+	reg := trace.NewRegistry()
+	reg.Define(SiteTestHandler, "x")
+
+	h, err := statsd.New("udp", pc.LocalAddr().String(), 11, 0, reg)
+	require.NoError(t, err)
+	defer h.Close()
+
+	require.NoError(t, h.Count(SiteTestHandler, 1))
+	require.NoError(t, h.Count(SiteTestHandler, 2))
+	require.NoError(t, h.Count(SiteTestHandler, 3))
+	require.NoError(t, h.Flush())
+
+	require.Equal(t, "x:1|c\nx:2|c", read(t, pc))
+	require.Equal(t, "x:3|c", read(t, pc))
+}
+
+func read(t *testing.T, pc net.PacketConn) string {
+	buf := make([]byte, 2048)
+	require.NoError(t, pc.SetReadDeadline(time.Now().Add(time.Second)))
+	n, _, err := pc.ReadFrom(buf)
+	require.NoError(t, err)
+	return string(buf[:n])
+}
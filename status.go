@@ -0,0 +1,103 @@
+package trace
+
+import (
+	"context"
+	"errors"
+)
+
+// StatusCode is the outcome of a trace.
+type StatusCode int
+
+const (
+	StatusUnset     StatusCode = iota // StatusUnset means no outcome was recorded.
+	StatusOK                          // StatusOK means the work succeeded.
+	StatusError                       // StatusError means the work failed.
+	StatusCancelled                   // StatusCancelled means the work was cancelled.
+	StatusTimedOut                    // StatusTimedOut means the work ran out of time.
+)
+
+func (c StatusCode) String() string {
+	switch c {
+	case StatusOK:
+		return "ok"
+	case StatusError:
+		return "error"
+	case StatusCancelled:
+		return "cancelled"
+	case StatusTimedOut:
+		return "timed_out"
+	default:
+		return "unset"
+	}
+}
+
+// Status is the structured outcome of a trace.
+type Status struct {
+	Code        StatusCode // Code classifies the outcome.
+	Description string     // Description explains the outcome, if it is not OK.
+	Err         error      // Err is the error that caused the outcome, if any.
+}
+
+// Failed returns true if the status represents an error, cancellation or
+// timeout.
+func (s Status) Failed() bool {
+	switch s.Code {
+	case StatusError, StatusCancelled, StatusTimedOut:
+		return true
+	default:
+		return false
+	}
+}
+
+// Attrs returns the status as attrs suitable for logging.
+func (s Status) Attrs() []Attr {
+	attrs := []Attr{String("status", s.Code.String())}
+	if s.Description != "" {
+		attrs = append(attrs, String("status_description", s.Description))
+	}
+	if s.Err != nil {
+		attrs = append(attrs, Error(s.Err))
+	}
+	return attrs
+}
+
+// StatusFromError classifies err. A nil error is OK; context.Canceled and
+// context.DeadlineExceeded are cancellations and timeouts respectively; any
+// other error is an error.
+func StatusFromError(err error) Status {
+	switch {
+	case err == nil:
+		return Status{Code: StatusOK}
+	case errors.Is(err, context.Canceled):
+		return Status{Code: StatusCancelled, Description: err.Error(), Err: err}
+	case errors.Is(err, context.DeadlineExceeded):
+		return Status{Code: StatusTimedOut, Description: err.Error(), Err: err}
+	default:
+		return Status{Code: StatusError, Description: err.Error(), Err: err}
+	}
+}
+
+func (st *tracestate) getStatus() Status {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.status
+}
+
+func (st *tracestate) setStatus(s Status) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.status = s
+}
+
+// finish sets the status to OK if no status was recorded and returns the
+// final status and the number of times the trace has been closed, including
+// this one.
+func (st *tracestate) finish() (Status, int) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.status.Code == StatusUnset {
+		st.status.Code = StatusOK
+	}
+	st.closes++
+	return st.status, st.closes
+}
@@ -0,0 +1,201 @@
+package trace
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultSubscriberBuffer is the number of Records buffered for a subscriber
+// when its Filter does not set Buffer.
+const DefaultSubscriberBuffer = 256
+
+// A Filter selects the events delivered to a subscriber. The zero Filter
+// selects every event.
+type Filter struct {
+	// Level is the least severe level delivered, or zero for every level.
+	Level Level
+
+	// Sites is a pattern, as accepted by MatchIdentifier, that selects the
+	// sites whose events are delivered, or empty for every site. Sites are
+	// identified by Registry; sites it does not define have the identifier
+	// "".
+	Sites    string
+	Registry Registry
+
+	// Match selects the events whose attrs, including those of the trace,
+	// include an attr with the same key and formatted value as each attr in
+	// Match.
+	Match []Attr
+
+	// Where, if not nil, selects the events for which it returns true. It is
+	// called by the goroutine that logs the event, so it must be fast.
+	Where func(Record) bool
+
+	// Buffer is the number of Records buffered for the subscriber, or
+	// DefaultSubscriberBuffer if not positive.
+	Buffer int
+}
+
+/*
+Subscribe returns a channel that receives the events selected by filter from
+every Tracepoint that has a Handler installed, and a function that cancels
+the subscription and closes the channel.
+
+Events are delivered regardless of the levels of the handlers, the default
+level and the sites enabled by SetSiteEnabled. Sites without a Handler
+originate no traces, so they have no events to deliver. An event that does
+not fit in the subscriber's buffer is dropped rather than blocking the code
+that logged it; Dropped counts them.
+
+The Records hold copies of the attrs of their events. Their Trace only
+identifies the trace that logged the event, by its site, ID and attrs; its
+other methods do nothing.
+*/
+func Subscribe(filter Filter) (<-chan Record, func()) {
+	if filter.Level == 0 {
+		filter.Level = NoiseLevel
+	}
+	if filter.Buffer <= 0 {
+		filter.Buffer = DefaultSubscriberBuffer
+	}
+	if filter.Registry == nil {
+		filter.Registry = NewRegistry()
+	}
+
+	s := &subscriber{
+		filter: filter,
+		ch:     make(chan Record, filter.Buffer),
+	}
+	subscribers.add(s)
+
+	var once sync.Once
+	return s.ch, func() {
+		once.Do(func() {
+			subscribers.remove(s)
+			s.close()
+		})
+	}
+}
+
+// Dropped returns the number of events dropped because the buffer of the
+// subscription that returned ch was full. It returns zero once the
+// subscription is cancelled.
+func Dropped(ch <-chan Record) uint64 {
+	for _, s := range subscribers.load() {
+		if (<-chan Record)(s.ch) == ch {
+			return atomic.LoadUint64(&s.dropped)
+		}
+	}
+	return 0
+}
+
+// subscribers holds the current subscriptions.
+var subscribers subscriberset
+
+type subscriberset struct {
+	mu  sync.Mutex
+	arr atomic.Pointer[[]*subscriber] // copied on write
+}
+
+func (ss *subscriberset) load() []*subscriber {
+	if p := ss.arr.Load(); p != nil {
+		return *p
+	}
+	return nil
+}
+
+func (ss *subscriberset) add(s *subscriber) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	arr := ss.load()
+	arr = append(arr[:len(arr):len(arr)], s)
+	ss.arr.Store(&arr)
+}
+
+func (ss *subscriberset) remove(s *subscriber) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	var arr []*subscriber
+	for _, v := range ss.load() {
+		if v != s {
+			arr = append(arr, v)
+		}
+	}
+	if len(arr) == 0 {
+		ss.arr.Store(nil)
+		return
+	}
+	ss.arr.Store(&arr)
+}
+
+// wants reports whether any subscriber in arr accepts events at level.
+func wants(arr []*subscriber, level Level) bool {
+	for _, s := range arr {
+		if level <= s.filter.Level {
+			return true
+		}
+	}
+	return false
+}
+
+// publish delivers r to the subscribers in arr whose filters select it.
+func publish(arr []*subscriber, r Record) {
+	for _, s := range arr {
+		if s.selects(r) {
+			s.send(r)
+		}
+	}
+}
+
+type subscriber struct {
+	filter  Filter
+	dropped uint64 // accessed atomically
+
+	mu     sync.RWMutex // guards ch against being closed while sending
+	closed bool
+	ch     chan Record
+}
+
+func (s *subscriber) selects(r Record) bool {
+	f := &s.filter
+	if r.Level > f.Level {
+		return false
+	}
+	if f.Sites != "" {
+		id, _ := f.Registry.IdentifierFor(r.Site)
+		if !MatchIdentifier(f.Sites, id) {
+			return false
+		}
+	}
+	if len(f.Match) > 0 {
+		var attrs []Attr
+		r.Attrs(func(a Attr) bool {
+			attrs = append(attrs, a)
+			return true
+		})
+		if !matchAttrs(f.Match, attrs) {
+			return false
+		}
+	}
+	return f.Where == nil || f.Where(r)
+}
+
+func (s *subscriber) send(r Record) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		return
+	}
+	select {
+	case s.ch <- r:
+	default:
+		atomic.AddUint64(&s.dropped, 1)
+	}
+}
+
+func (s *subscriber) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	close(s.ch)
+}
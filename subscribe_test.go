@@ -0,0 +1,97 @@
+package trace_test
+
+import (
+	"io"
+	"testing"
+
+	"github.com/dzrw/trace"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	SiteTestSubscribeQuery = trace.Site()
+	SiteTestSubscribeHTTP  = trace.Site()
+)
+
+func TestSubscribe(t *testing.T) {
+	reg := trace.NewRegistry()
+	reg.Define(SiteTestSubscribeQuery, "db.query")
+	reg.Define(SiteTestSubscribeHTTP, "http.request")
+
+	// The installed handler accepts errors only; the subscriber still sees
+	// the debug events it asks for.
+	rec := newMetricsRecorder()
+	SiteTestSubscribeQuery.Install(rec)
+	defer SiteTestSubscribeQuery.Uninstall()
+	SiteTestSubscribeHTTP.Install(rec)
+	defer SiteTestSubscribeHTTP.Uninstall()
+
+	ch, cancel := trace.Subscribe(trace.Filter{
+		Level:    trace.DebugLevel,
+		Sites:    "db.*",
+		Registry: reg,
+		Match:    []trace.Attr{trace.String("tenant", "acme")},
+	})
+
+	tr := SiteTestSubscribeQuery.Trace(trace.String("tenant", "acme"))
+	tr.Debug("selected", trace.Int("rows", 3))
+	tr.Log(trace.NoiseLevel, trace.Event("too verbose"))
+	SiteTestSubscribeQuery.Trace(trace.String("tenant", "other")).Info("wrong tenant")
+	SiteTestSubscribeHTTP.Trace(trace.String("tenant", "acme")).Error("wrong site")
+
+	r := <-ch
+	require.Equal(t, "selected", r.Message)
+	require.Equal(t, trace.DebugLevel, r.Level)
+	require.Equal(t, SiteTestSubscribeQuery, r.Site)
+	require.Equal(t, 2, r.NumAttrs())
+	_, file, _ := r.Source()
+	require.Contains(t, file, "subscribe_test.go")
+
+	cancel()
+	_, ok := <-ch
+	require.False(t, ok)
+	cancel()
+}
+
+func TestSubscribeDrops(t *testing.T) {
+	ch, cancel := trace.Subscribe(trace.Filter{Buffer: 2})
+	defer cancel()
+
+	// Subscribers receive events that the handler does not accept.
+	SiteTestSubscribeHTTP.Install(trace.NewTextHandler(io.Discard, trace.ErrorLevel, false, false, nil))
+	defer SiteTestSubscribeHTTP.Uninstall()
+	tr := SiteTestSubscribeHTTP.Trace()
+	for i := 0; i < 5; i++ {
+		tr.Info("event")
+	}
+
+	require.Len(t, ch, 2)
+	require.Equal(t, uint64(3), trace.Dropped(ch))
+}
+
+func TestSubscribeCopiesAttrs(t *testing.T) {
+	SiteTestSubscribeHTTP.Install(newMetricsRecorder())
+	defer SiteTestSubscribeHTTP.Uninstall()
+
+	ch, cancel := trace.Subscribe(trace.Filter{})
+	defer cancel()
+
+	attrs := []trace.Attr{trace.String("key", "before")}
+	tr := SiteTestSubscribeHTTP.Trace(trace.String("user", "u1"))
+	tr.Info("event", attrs...)
+	attrs[0] = trace.String("key", "after")
+	tr.Close()
+
+	// The Record keeps its own attrs, and identifies its trace.
+	r := <-ch
+	var keys []string
+	r.Attrs(func(a trace.Attr) bool {
+		_, v := a.Format()
+		keys = append(keys, a.Key()+"="+v)
+		return true
+	})
+	require.Equal(t, []string{"user=u1", "key=before"}, keys)
+	require.Equal(t, tr.ID(), r.Trace.ID())
+	require.Equal(t, SiteTestSubscribeHTTP, r.Trace.Site())
+	require.Equal(t, trace.Status{}, r.Trace.Status())
+}
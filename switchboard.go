@@ -0,0 +1,252 @@
+package trace
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var _ = Handler(&Switchboard{})
+var _ = RecordHandler(&Switchboard{})
+var _ = ContextHandler(&Switchboard{})
+var _ = LinkHandler(&Switchboard{})
+var _ = Flusher(&Switchboard{})
+var _ = Closer(&Switchboard{})
+var _ = HandlerGroup(&Switchboard{})
+
+/*
+Switchboard is a Handler that forwards everything to another Handler, the
+root of a handler graph, which can be replaced atomically. Install a
+Switchboard into each Tracepoint once, e.g.
+
+	sb := trace.NewSwitchboard(h)
+	for _, tp := range trace.Sites() {
+		tp.Install(sb)
+	}
+
+and reconfigure the process with Swap, so that every site switches to the new
+graph at the same instant. A Tracepoint into which a Switchboard is installed
+resolves the current graph once per trace or event, so that the checks and
+the delivery of an event all reach the same graph.
+*/
+type Switchboard struct {
+	cur atomic.Value // holds a *generation
+}
+
+// generation is a handler graph installed into a Switchboard. Calls hold a
+// read lock on their generation, so that Swap can wait for the calls that are
+// in flight before draining it.
+type generation struct {
+	h       Handler
+	ch      ContextHandler
+	rh      RecordHandler
+	mu      sync.RWMutex
+	retired bool
+}
+
+// NewSwitchboard creates a Switchboard that forwards to h, which may be nil.
+func NewSwitchboard(h Handler) *Switchboard {
+	sb := &Switchboard{}
+	sb.cur.Store(newGeneration(h))
+	return sb
+}
+
+// newGeneration creates a generation for h, adapting h once for the calls
+// that need a ContextHandler or a RecordHandler.
+func newGeneration(h Handler) *generation {
+	if h == nil {
+		return &generation{}
+	}
+	return &generation{h: h, ch: contextHandler(h), rh: AdaptHandler(h)}
+}
+
+// Current returns the root of the current handler graph, or nil.
+func (sb *Switchboard) Current() Handler {
+	return sb.cur.Load().(*generation).h
+}
+
+/*
+Swap replaces the current handler graph with the one rooted at h, which may
+be nil. Once the calls to the old graph that are in flight have returned, its
+root is flushed and closed, unless it is also the root of the new graph. The
+returned error is a *ShutdownError if the old graph failed to drain before ctx
+was done.
+
+Swap waits for the calls in flight, so it must not be called from the methods
+of a Handler in the graph, nor while creating a trace or logging an event
+through the Switchboard: it would wait for itself forever. Swap from another
+goroutine instead.
+*/
+func (sb *Switchboard) Swap(ctx context.Context, h Handler) error {
+	old := sb.cur.Swap(newGeneration(h)).(*generation)
+
+	old.mu.Lock()
+	old.retired = true
+	old.mu.Unlock()
+
+	if old.h == nil || old.h == h {
+		return nil
+	}
+	return drain(ctx, []Handler{old.h})
+}
+
+// acquire returns the current generation, read locked.
+func (sb *Switchboard) acquire() *generation {
+	for {
+		g := sb.cur.Load().(*generation)
+		g.mu.RLock()
+		if !g.retired {
+			return g
+		}
+		g.mu.RUnlock()
+	}
+}
+
+func (sb *Switchboard) Flags() HandlerFlags {
+	g := sb.acquire()
+	defer g.mu.RUnlock()
+	if g.h == nil {
+		return 0
+	}
+	return g.h.Flags()
+}
+
+func (sb *Switchboard) Enabled(l Level) bool {
+	g := sb.acquire()
+	defer g.mu.RUnlock()
+	return g.h != nil && g.h.Enabled(l)
+}
+
+func (sb *Switchboard) EnabledContext(ctx context.Context, l Level) bool {
+	g := sb.acquire()
+	defer g.mu.RUnlock()
+	return g.h != nil && g.ch.EnabledContext(ctx, l)
+}
+
+func (sb *Switchboard) Count(tp Tracepoint, delta int64) error {
+	g := sb.acquire()
+	defer g.mu.RUnlock()
+	if g.h == nil {
+		return nil
+	}
+	return g.h.Count(tp, delta)
+}
+
+func (sb *Switchboard) Gauge(tp Tracepoint, value int64) error {
+	g := sb.acquire()
+	defer g.mu.RUnlock()
+	if g.h == nil {
+		return nil
+	}
+	return g.h.Gauge(tp, value)
+}
+
+func (sb *Switchboard) Duration(tp Tracepoint, d time.Duration) error {
+	g := sb.acquire()
+	defer g.mu.RUnlock()
+	if g.h == nil {
+		return nil
+	}
+	return g.h.Duration(tp, d)
+}
+
+func (sb *Switchboard) Histogram(tp Tracepoint, sample int64) error {
+	g := sb.acquire()
+	defer g.mu.RUnlock()
+	if g.h == nil {
+		return nil
+	}
+	return g.h.Histogram(tp, sample)
+}
+
+func (sb *Switchboard) Log(tr Trace, l Level, attrs ...[]Attr) error {
+	g := sb.acquire()
+	defer g.mu.RUnlock()
+	if g.h == nil {
+		return nil
+	}
+	return g.h.Log(tr, l, attrs...)
+}
+
+func (sb *Switchboard) Handle(r Record) error {
+	g := sb.acquire()
+	defer g.mu.RUnlock()
+	if g.h == nil {
+		return nil
+	}
+	return g.rh.Handle(r)
+}
+
+func (sb *Switchboard) HandleContext(ctx context.Context, r Record) error {
+	g := sb.acquire()
+	defer g.mu.RUnlock()
+	if g.h == nil {
+		return nil
+	}
+	return g.ch.HandleContext(ctx, r)
+}
+
+func (sb *Switchboard) TraceCreated(tr Trace, attrs []Attr) {
+	g := sb.acquire()
+	defer g.mu.RUnlock()
+	if g.h != nil {
+		g.h.TraceCreated(tr, attrs)
+	}
+}
+
+func (sb *Switchboard) TraceCreatedContext(ctx context.Context, tr Trace, attrs []Attr) {
+	g := sb.acquire()
+	defer g.mu.RUnlock()
+	if g.h != nil {
+		g.ch.TraceCreatedContext(ctx, tr, attrs)
+	}
+}
+
+func (sb *Switchboard) TraceFinished(tr Trace, attrs []Attr) {
+	g := sb.acquire()
+	defer g.mu.RUnlock()
+	if g.h != nil {
+		g.h.TraceFinished(tr, attrs)
+	}
+}
+
+func (sb *Switchboard) TraceFinishedContext(ctx context.Context, tr Trace, attrs []Attr) {
+	g := sb.acquire()
+	defer g.mu.RUnlock()
+	if g.h != nil {
+		g.ch.TraceFinishedContext(ctx, tr, attrs)
+	}
+}
+
+func (sb *Switchboard) TraceLinked(tr Trace, l Link) {
+	g := sb.acquire()
+	defer g.mu.RUnlock()
+	if lh, ok := g.h.(LinkHandler); ok {
+		lh.TraceLinked(tr, l)
+	}
+}
+
+// Handlers returns the root of the current handler graph, if any.
+func (sb *Switchboard) Handlers() []Handler {
+	if h := sb.Current(); h != nil {
+		return []Handler{h}
+	}
+	return nil
+}
+
+// Flush flushes the current handler graph if its root is a Flusher.
+func (sb *Switchboard) Flush() error {
+	g := sb.acquire()
+	defer g.mu.RUnlock()
+	if f, ok := g.h.(Flusher); ok {
+		return f.Flush()
+	}
+	return nil
+}
+
+// Close detaches the current handler graph and closes it, as if by a Swap to
+// nil without a deadline.
+func (sb *Switchboard) Close() error {
+	return sb.Swap(context.Background(), nil)
+}
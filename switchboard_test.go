@@ -0,0 +1,123 @@
+package trace_test
+
+import (
+	"bytes"
+	"context"
+	"runtime"
+	"sync"
+	"testing"
+
+	"github.com/dzrw/trace"
+	"github.com/stretchr/testify/require"
+)
+
+var SiteTestSwitchboard = trace.Site()
+
+func TestSwitchboard(t *testing.T) {
+	reg := trace.NewRegistry()
+	reg.Define(SiteTestSwitchboard, "trace_test.SiteTestSwitchboard")
+
+	first := &lifecycleRecorder{metricsRecorder: *newMetricsRecorder()}
+	sb := trace.NewSwitchboard(first)
+	SiteTestSwitchboard.Install(sb)
+	defer SiteTestSwitchboard.Uninstall()
+
+	SiteTestSwitchboard.Count(1)
+	require.Equal(t, int64(1), first.counts[SiteTestSwitchboard])
+
+	buf := bytes.Buffer{}
+	second := trace.NewTextHandler(&buf, trace.DebugLevel, false, false, reg)
+	require.NoError(t, sb.Swap(context.Background(), second))
+	require.Equal(t, 1, first.flushed)
+	require.Equal(t, 1, first.closed)
+	require.Equal(t, second, sb.Current())
+
+	SiteTestSwitchboard.Count(1)
+	require.Equal(t, int64(1), first.counts[SiteTestSwitchboard])
+	require.Equal(t, "site=trace_test.SiteTestSwitchboard count=1\n", buf.String())
+
+	require.NoError(t, sb.Close())
+	require.Nil(t, sb.Current())
+	SiteTestSwitchboard.Count(1)
+}
+
+func TestSwitchboardConcurrentSwap(t *testing.T) {
+	sb := trace.NewSwitchboard(newMetricsRecorder())
+	SiteTestSwitchboard.Install(sb)
+	defer SiteTestSwitchboard.Uninstall()
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+					tr := SiteTestSwitchboard.Trace()
+					tr.Info("hello")
+					tr.Close()
+				}
+			}
+		}()
+	}
+	for i := 0; i < 100; i++ {
+		require.NoError(t, sb.Swap(context.Background(), trace.NewAggregator(newMetricsRecorder(), 0)))
+	}
+	close(stop)
+	wg.Wait()
+}
+
+func TestSwitchboardAdaptsOnce(t *testing.T) {
+	sb := trace.NewSwitchboard(newMetricsRecorder())
+	ctx := context.Background()
+
+	// The downstream Handler is not a ContextHandler, so it is adapted when
+	// it is installed rather than on each call.
+	allocs := testing.AllocsPerRun(100, func() {
+		sb.EnabledContext(ctx, trace.InfoLevel)
+	})
+	require.Zero(t, allocs)
+}
+
+// swappingRecorder starts a Swap to next when it is asked whether it accepts
+// an event, and waits for the new graph to become current.
+type swappingRecorder struct {
+	contextRecorder
+	sb   *trace.Switchboard
+	next trace.Handler
+	done chan error
+}
+
+func (h *swappingRecorder) EnabledContext(ctx context.Context, l trace.Level) bool {
+	if h.next != nil {
+		next := h.next
+		h.next = nil
+		go func() { h.done <- h.sb.Swap(context.Background(), next) }()
+		for h.sb.Current() != next {
+			runtime.Gosched()
+		}
+	}
+	return true
+}
+
+func TestSwitchboardSnapshot(t *testing.T) {
+	second := &contextRecorder{}
+	first := &swappingRecorder{next: second, done: make(chan error, 1)}
+	sb := trace.NewSwitchboard(first)
+	first.sb = sb
+	SiteTestSwitchboard.Install(sb)
+	defer SiteTestSwitchboard.Uninstall()
+
+	// The event is delivered to the graph that accepted it, even though the
+	// graph was swapped in between.
+	tr := SiteTestSwitchboard.TraceContext(context.WithValue(context.Background(), tenantKey{}, "t1"))
+	tr.Info("hello")
+	require.NoError(t, <-first.done)
+	tr.Close()
+	require.Equal(t, []string{"created:t1", "event:t1"}, first.tenants)
+	require.Equal(t, []string{"finished:t1"}, second.tenants)
+}
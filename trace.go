@@ -1,6 +1,8 @@
 package trace
 
 import (
+	"context"
+	"sync"
 	"time"
 )
 
@@ -8,10 +10,37 @@ type Trace interface {
 	Site() Tracepoint       // Site returns the tracepoint that originated this trace.
 	ID() uint64             // ID returns the unique identifier for the trace
 	Elapsed() time.Duration // Elapsed returns the time elapsed since the trace started.
+	Attrs() []Attr          // Attrs returns the attrs carried on every event of the trace.
 
-	// Close closes the trace.
+	// With returns a Trace that shares this trace's identity and carries attrs
+	// in addition to this trace's attrs.
+	With(attrs ...Attr) Trace
+
+	// Close closes the trace. If no status was recorded, the trace's status
+	// becomes StatusOK.
 	Close(attrs ...Attr)
 
+	// CloseWithError records the outcome of err as the trace's status, as if
+	// by Fail, and closes the trace.
+	CloseWithError(err error, attrs ...Attr)
+
+	// Fail records the outcome of err as the trace's status. A nil error
+	// records StatusOK.
+	Fail(err error)
+
+	// SetStatus records the trace's status.
+	SetStatus(code StatusCode, description string)
+
+	// Status returns the trace's status.
+	Status() Status
+
+	// AddLink links the trace to the trace identified by rc, which caused it
+	// but is not its parent.
+	AddLink(rc RemoteContext, attrs ...Attr)
+
+	// Links returns the trace's links.
+	Links() []Link
+
 	// Error captures an event associated with this trace.
 	Error(event string, attrs ...Attr)
 
@@ -31,10 +60,21 @@ type Trace interface {
 	// an event associated with the trace at the AssertionViolated level;
 	// otherwise, log an event at the specified level.
 	Assert(level Level, attrs ...Attr)
+
+	// Recover closes the trace, so it replaces a deferred Close. If the
+	// goroutine is panicking, it first logs the panic and its stack at the
+	// Error level and records a *PanicError as the trace's status, then
+	// panics again or not according to the PanicPolicy. It must be deferred
+	// directly:
+	//
+	//	tr := SiteFoo.Trace()
+	//	defer tr.Recover()
+	Recover()
 }
 
 var _ = Trace(&noptraceimpl{})
 var _ = Trace(&traceimpl{})
+var _ = Trace(&detachedtrace{})
 
 type noptraceimpl struct{}
 
@@ -44,7 +84,15 @@ var noptrace = &noptraceimpl{}
 func (*noptraceimpl) Site() Tracepoint                  { return nil }
 func (*noptraceimpl) ID() uint64                        { return 0 }
 func (*noptraceimpl) Elapsed() time.Duration            { return time.Duration(0) }
+func (*noptraceimpl) Attrs() []Attr                     { return nil }
+func (*noptraceimpl) With(attrs ...Attr) Trace          { return noptrace }
 func (*noptraceimpl) Close(attrs ...Attr)               {}
+func (*noptraceimpl) CloseWithError(error, ...Attr)     {}
+func (*noptraceimpl) Fail(err error)                    {}
+func (*noptraceimpl) SetStatus(StatusCode, string)      {}
+func (*noptraceimpl) Status() Status                    { return Status{} }
+func (*noptraceimpl) AddLink(RemoteContext, ...Attr)    {}
+func (*noptraceimpl) Links() []Link                     { return nil }
 func (*noptraceimpl) Error(event string, attrs ...Attr) {}
 func (*noptraceimpl) Warn(event string, attrs ...Attr)  {}
 func (*noptraceimpl) Info(event string, attrs ...Attr)  {}
@@ -52,11 +100,48 @@ func (*noptraceimpl) Debug(event string, attrs ...Attr) {}
 func (*noptraceimpl) Log(level Level, attrs ...Attr)    {}
 func (*noptraceimpl) Assert(level Level, attrs ...Attr) {}
 
+// detachedtrace stands for a trace in a Record that outlives the event, like
+// those kept by subscribers and the FlightRecorder. It holds the site, ID and
+// attrs of the trace, but not the trace itself, so that the trace can be
+// collected. Its other methods do nothing.
+type detachedtrace struct {
+	noptraceimpl
+	tp    Tracepoint
+	id    uint64
+	attrs []Attr
+}
+
+// detach returns a detachedtrace for tr.
+func detach(tr Trace) Trace {
+	return &detachedtrace{tp: tr.Site(), id: tr.ID(), attrs: tr.Attrs()}
+}
+
+func (tr *detachedtrace) Site() Tracepoint { return tr.tp }
+func (tr *detachedtrace) ID() uint64       { return tr.id }
+func (tr *detachedtrace) Attrs() []Attr    { return tr.attrs }
+
+func (tr *detachedtrace) With(attrs ...Attr) Trace {
+	return &detachedtrace{tp: tr.tp, id: tr.id, attrs: append(tr.attrs[:len(tr.attrs):len(tr.attrs)], attrs...)}
+}
+
 type traceimpl struct {
+	ctx   context.Context
 	tp    *tracepoint
 	id    uint64
 	then  time.Time
 	attrs []Attr
+	pre   sync.Map // map[Handler]string, see Preformat
+	st    *tracestate
+}
+
+// tracestate holds the mutable state of a trace. It is shared by the traces
+// derived from it by With.
+type tracestate struct {
+	mu     sync.Mutex
+	status Status
+	links  []Link
+	closes int
+	leak   *leakentry // set if a LeakDetector tracks the trace
 }
 
 func (tr *traceimpl) Site() Tracepoint {
@@ -71,28 +156,79 @@ func (tr *traceimpl) Elapsed() time.Duration {
 	return time.Since(tr.then)
 }
 
+func (tr *traceimpl) Attrs() []Attr {
+	return tr.attrs
+}
+
+func (tr *traceimpl) With(attrs ...Attr) Trace {
+	if len(attrs) == 0 {
+		return tr
+	}
+	arr := make([]Attr, 0, len(tr.attrs)+len(attrs))
+	arr = append(arr, tr.attrs...)
+	arr = append(arr, attrs...)
+	return &traceimpl{
+		ctx:   tr.ctx,
+		tp:    tr.tp,
+		id:    tr.id,
+		then:  tr.then,
+		attrs: arr,
+		st:    tr.st,
+	}
+}
+
 func (tr *traceimpl) Close(attrs ...Attr) {
-	tr.tp.finishTrace(tr, attrs)
+	if _, n := tr.st.finish(); tr.st.leak != nil {
+		tr.st.leak.closed(n)
+	}
+	tr.tp.finishTrace(tr.ctx, tr, attrs)
+}
+
+func (tr *traceimpl) CloseWithError(err error, attrs ...Attr) {
+	tr.Fail(err)
+	tr.Close(attrs...)
+}
+
+func (tr *traceimpl) Fail(err error) {
+	tr.st.setStatus(StatusFromError(err))
+}
+
+func (tr *traceimpl) SetStatus(code StatusCode, description string) {
+	tr.st.setStatus(Status{Code: code, Description: description})
+}
+
+func (tr *traceimpl) Status() Status {
+	return tr.st.getStatus()
+}
+
+func (tr *traceimpl) AddLink(rc RemoteContext, attrs ...Attr) {
+	l := Link{Context: rc, Attrs: attrs}
+	tr.st.addLink(l)
+	tr.tp.linkTrace(tr, l)
+}
+
+func (tr *traceimpl) Links() []Link {
+	return tr.st.getLinks()
 }
 
 func (tr *traceimpl) Error(event string, attrs ...Attr) {
-	tr.tp.log(tr, 2, ErrorLevel, append(attrs, Event(event)))
+	tr.tp.log(tr.ctx, tr, 2, ErrorLevel, event, attrs)
 }
 
 func (tr *traceimpl) Warn(event string, attrs ...Attr) {
-	tr.tp.log(tr, 2, WarnLevel, append(attrs, Event(event)))
+	tr.tp.log(tr.ctx, tr, 2, WarnLevel, event, attrs)
 }
 
 func (tr *traceimpl) Info(event string, attrs ...Attr) {
-	tr.tp.log(tr, 2, InfoLevel, append(attrs, Event(event)))
+	tr.tp.log(tr.ctx, tr, 2, InfoLevel, event, attrs)
 }
 
 func (tr *traceimpl) Debug(event string, attrs ...Attr) {
-	tr.tp.log(tr, 2, DebugLevel, append(attrs, Event(event)))
+	tr.tp.log(tr.ctx, tr, 2, DebugLevel, event, attrs)
 }
 
 func (tr *traceimpl) Log(level Level, attrs ...Attr) {
-	tr.tp.log(tr, 2, level, attrs)
+	tr.tp.log(tr.ctx, tr, 2, level, "", attrs)
 }
 
 func (tr *traceimpl) Assert(level Level, attrs ...Attr) {
@@ -102,5 +238,21 @@ func (tr *traceimpl) Assert(level Level, attrs ...Attr) {
 			break
 		}
 	}
-	tr.tp.log(tr, 2, level, attrs)
+	tr.tp.log(tr.ctx, tr, 2, level, "", attrs)
+}
+
+// Preformat returns the result of format applied to the attrs of tr. For
+// traces originated by a Tracepoint, the result is computed at most once per
+// trace and handler. Handlers that set FlagPreformatAttrs use Preformat to
+// render the trace's attrs, since they are not passed to Handler.Log.
+func Preformat(tr Trace, h Handler, format func([]Attr) string) string {
+	impl, ok := tr.(*traceimpl)
+	if !ok {
+		return format(tr.Attrs())
+	}
+	if v, ok := impl.pre.Load(h); ok {
+		return v.(string)
+	}
+	v, _ := impl.pre.LoadOrStore(h, format(impl.attrs))
+	return v.(string)
 }
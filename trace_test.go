@@ -0,0 +1,112 @@
+package trace_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/dzrw/trace"
+	"github.com/stretchr/testify/require"
+)
+
+var SiteTestTraceAttrs = trace.Site()
+
+var traceID = regexp.MustCompile(`trace=\d+`)
+
+func TestTraceAttrs(t *testing.T) {
+	reg := trace.NewRegistry()
+	reg.Define(SiteTestTraceAttrs, "trace_test.SiteTestTraceAttrs")
+
+	for _, flags := range []trace.HandlerFlags{0, trace.FlagPreformatAttrs} {
+		buf := bytes.Buffer{}
+		SiteTestTraceAttrs.Install(trace.NewTextHandlerWithFlags(&buf, trace.DebugLevel, flags, reg))
+
+		tr := SiteTestTraceAttrs.Trace(trace.String("user_id", "u1"))
+		tr.Info("first")
+		tr.With(trace.Int("attempt", 2)).Info("second")
+		tr.Close()
+
+		out := traceID.ReplaceAllString(buf.String(), "trace=N")
+		lines := strings.Split(strings.TrimSpace(out), "\n")
+		require.Len(t, lines, 4)
+		require.Equal(t, "site=trace_test.SiteTestTraceAttrs trace=N event=\"trace created\" user_id=u1", lines[0])
+		require.Equal(t, "site=trace_test.SiteTestTraceAttrs trace=N user_id=u1 event=first", lines[1])
+		require.Equal(t, "site=trace_test.SiteTestTraceAttrs trace=N user_id=u1 attempt=2 event=second", lines[2])
+		require.True(t, strings.HasPrefix(lines[3], "site=trace_test.SiteTestTraceAttrs trace=N user_id=u1 event=\"trace finished\""))
+	}
+	SiteTestTraceAttrs.Uninstall()
+}
+
+func TestPreformat(t *testing.T) {
+	reg := trace.NewRegistry()
+	h := trace.NewTextHandler(&bytes.Buffer{}, trace.DebugLevel, false, false, reg)
+	SiteTestTraceAttrs.Install(h)
+	defer SiteTestTraceAttrs.Uninstall()
+
+	calls := 0
+	format := func(attrs []trace.Attr) string {
+		calls++
+		return attrs[0].String()
+	}
+
+	tr := SiteTestTraceAttrs.Trace(trace.String("k", "v"))
+	require.Equal(t, "v", trace.Preformat(tr, h, format))
+	require.Equal(t, "v", trace.Preformat(tr, h, format))
+	require.Equal(t, 1, calls)
+}
+
+func TestTraceStatus(t *testing.T) {
+	h := trace.NewAggregator(newMetricsRecorder(), 0)
+	SiteTestTraceAttrs.Install(h)
+	defer SiteTestTraceAttrs.Uninstall()
+
+	tr := SiteTestTraceAttrs.Trace()
+	tr.Close()
+	require.Equal(t, trace.StatusOK, tr.Status().Code)
+
+	tr = SiteTestTraceAttrs.Trace()
+	tr.With(trace.Int("attempt", 1)).Fail(context.Canceled)
+	require.Equal(t, trace.StatusCancelled, tr.Status().Code)
+	tr.Close()
+	require.Equal(t, trace.StatusCancelled, tr.Status().Code)
+
+	tr = SiteTestTraceAttrs.Trace()
+	tr.CloseWithError(errors.New("boom"))
+	require.Equal(t, trace.Status{Code: trace.StatusError, Description: "boom", Err: tr.Status().Err}, tr.Status())
+
+	snap := h.Snapshot()
+	require.Len(t, snap, 1)
+	require.Equal(t, int64(2), snap[0].Errors)
+}
+
+func TestTraceLinks(t *testing.T) {
+	reg := trace.NewRegistry()
+	reg.Define(SiteTestTraceAttrs, "trace_test.SiteTestTraceAttrs")
+
+	buf := bytes.Buffer{}
+	SiteTestTraceAttrs.Install(trace.NewTextHandler(&buf, trace.DebugLevel, false, false, reg))
+	defer SiteTestTraceAttrs.Uninstall()
+
+	cause := SiteTestTraceAttrs.Trace()
+	cause.Close()
+
+	rc, err := trace.ParseRemoteContext(trace.RemoteContextOf(cause).String())
+	require.NoError(t, err)
+	require.Equal(t, SiteTestTraceAttrs.ID(), rc.Site)
+	require.Equal(t, cause.ID(), rc.Trace)
+
+	tr := SiteTestTraceAttrs.Trace(trace.String("k", "v"), trace.LinkTo(rc))
+	require.Equal(t, []trace.Attr{trace.String("k", "v")}, tr.Attrs())
+	tr.AddLink(rc, trace.String("batch", "b1"))
+	require.Equal(t, []trace.Link{
+		{Context: rc},
+		{Context: rc, Attrs: []trace.Attr{trace.String("batch", "b1")}},
+	}, tr.Links())
+
+	out := buf.String()
+	require.Contains(t, out, "event=\"trace created\" k=v link="+rc.String()+"\n")
+	require.Contains(t, out, "event=\"trace linked\" link="+rc.String()+" batch=b1\n")
+}
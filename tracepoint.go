@@ -1,9 +1,10 @@
 package trace
 
 import (
-	"fmt"
+	"context"
 	"runtime"
-	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/segmentio/ksuid"
@@ -32,6 +33,11 @@ type Tracepoint interface {
 
 	Trace(...Attr) Trace // Trace originates a new Trace from this tracepoint.
 
+	// TraceContext originates a new Trace from this tracepoint. The context
+	// is passed to handlers that implement ContextHandler along with the
+	// trace and its events.
+	TraceContext(context.Context, ...Attr) Trace
+
 	Count(delta int64)        // Count captures a delta from this tracepoint.
 	Gauge(value int64)        // Gauge captures a value from this tracepoint.
 	Duration(d time.Duration) // Duration captures a duration from this tracepoint.
@@ -39,16 +45,61 @@ type Tracepoint interface {
 }
 
 func Site() Tracepoint {
-	tp := &tracepoint{
+	tp := newTracepoint()
+	sites.add(tp)
+	return tp
+}
+
+// newTracepoint creates a Tracepoint that is not among Sites.
+func newTracepoint() *tracepoint {
+	return &tracepoint{
 		id: ksuid.New(),
 	}
-	return tp
+}
+
+// Sites returns every Tracepoint created by Site, in order of creation.
+func Sites() []Tracepoint {
+	all := sites.all()
+	arr := make([]Tracepoint, len(all))
+	for i, tp := range all {
+		arr[i] = tp
+	}
+	return arr
+}
+
+// sites holds every Tracepoint created by Site.
+var sites siteset
+
+type siteset struct {
+	mu  sync.Mutex
+	arr []*tracepoint
+}
+
+func (s *siteset) add(tp *tracepoint) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.arr = append(s.arr, tp)
+}
+
+func (s *siteset) all() []*tracepoint {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*tracepoint(nil), s.arr...)
 }
 
 type tracepoint struct {
-	id   ksuid.KSUID
-	next uint64
-	h    Handler
+	id       ksuid.KSUID
+	next     uint64       // accessed atomically
+	disabled uint32       // accessed atomically; see SetSiteEnabled
+	h        atomic.Value // holds a handlerbox
+}
+
+// handlerbox lets a nil Handler be stored in an atomic.Value. It also holds
+// the Handler as a ContextHandler, so that it is adapted once when it is
+// installed rather than on every event.
+type handlerbox struct {
+	h  Handler
+	ch ContextHandler
 }
 
 func (tp *tracepoint) ID() ksuid.KSUID {
@@ -56,19 +107,83 @@ func (tp *tracepoint) ID() ksuid.KSUID {
 }
 
 func (tp *tracepoint) Install(h Handler) {
-	tp.h = h
+	if h == nil {
+		tp.h.Store(handlerbox{})
+		return
+	}
+	tp.h.Store(handlerbox{h, contextHandler(h)})
 }
 
 func (tp *tracepoint) Uninstall() {
-	tp.h = nil
+	tp.h.Store(handlerbox{})
 }
 
 func (tp *tracepoint) Handler() (h Handler, ok bool) {
-	return tp.h, tp.h != nil
+	b, _ := tp.h.Load().(handlerbox)
+	return b.h, b.h != nil
+}
+
+// contextHandler returns the installed Handler as a ContextHandler.
+func (tp *tracepoint) contextHandler() (h Handler, ch ContextHandler, ok bool) {
+	b, _ := tp.h.Load().(handlerbox)
+	return b.h, b.ch, b.h != nil
+}
+
+// snapshot is like contextHandler, but if the installed Handler is a
+// Switchboard, it returns the root of the Switchboard's current generation,
+// read locked, so that every call made for an event reaches the same handler
+// graph. The caller must unlock g if it is not nil.
+func (tp *tracepoint) snapshot() (h Handler, ch ContextHandler, g *generation) {
+	b, _ := tp.h.Load().(handlerbox)
+	if sb, ok := b.h.(*Switchboard); ok {
+		g = sb.acquire()
+		return g.h, g.ch, g
+	}
+	return b.h, b.ch, nil
 }
 
 func (tp *tracepoint) Trace(attrs ...Attr) Trace {
-	if h, ok := tp.Handler(); ok {
+	return tp.trace(context.Background(), 2, attrs)
+}
+
+func (tp *tracepoint) TraceContext(ctx context.Context, attrs ...Attr) Trace {
+	return tp.trace(ctx, 2, attrs)
+}
+
+func (tp *tracepoint) trace(ctx context.Context, skip int, attrs []Attr) Trace {
+	h, ch, g := tp.snapshot()
+	if g != nil {
+		defer g.mu.RUnlock()
+	}
+	if h != nil {
+		tr := &traceimpl{
+			ctx:   ctx,
+			tp:    tp,
+			id:    atomic.AddUint64(&tp.next, 1) - 1,
+			then:  time.Now(),
+			attrs: attrs,
+			st:    &tracestate{},
+		}
+
+		if d := leakDetector.Load(); d != nil {
+			d.track(tr, skip+1)
+		}
+
+		// Links describe the creation of the trace, so they are not carried
+		// on the trace's events.
+		for _, a := range attrs {
+			if a.Kind() == LinkKind {
+				tr.st.links = append(tr.st.links, Link{Context: a.RemoteContext()})
+			}
+		}
+		if len(tr.st.links) > 0 {
+			tr.attrs = withoutLinks(attrs)
+		}
+
+		// Source information describes the creation of the trace, so it is
+		// not carried on the trace's events.
+		attrs = attrs[:len(attrs):len(attrs)]
+
 		flags := h.Flags()
 		if (flags & FlagGoroutineID) == FlagGoroutineID {
 			if gid := __caution__GetGoroutineID(); gid > 0 {
@@ -77,25 +192,13 @@ func (tp *tracepoint) Trace(attrs ...Attr) Trace {
 		}
 
 		if (flags & FlagSourceInfo) == FlagSourceInfo {
-			if pc, file, line, ok := runtime.Caller(1); ok {
-				fileAttr := String("file", fmt.Sprint(file, ":", strconv.Itoa(line)))
-				attrs = append(attrs, fileAttr)
-				if f := runtime.FuncForPC(pc); f != nil {
-					funcAttr := String("func", f.Name())
-					attrs = append(attrs, funcAttr)
-				}
+			var pcs [1]uintptr
+			if runtime.Callers(skip+1, pcs[:]) > 0 {
+				attrs = append(attrs, Source(pcs[0]))
 			}
 		}
 
-		tr := &traceimpl{
-			tp:    tp,
-			id:    tp.next,
-			then:  time.Now(),
-			attrs: attrs,
-		}
-
-		tp.next++
-		h.TraceCreated(tr, tr.attrs)
+		ch.TraceCreatedContext(ctx, tr, attrs)
 		return tr
 	}
 
@@ -105,57 +208,138 @@ func (tp *tracepoint) Trace(attrs ...Attr) Trace {
 
 func (tp *tracepoint) Count(delta int64) {
 	if h, ok := tp.Handler(); ok {
-		h.Count(tp, delta)
+		if err := h.Count(tp, delta); err != nil {
+			ReportError(h, "Count", err)
+		}
 	}
 }
 
 func (tp *tracepoint) Gauge(value int64) {
 	if h, ok := tp.Handler(); ok {
-		h.Gauge(tp, value)
+		if err := h.Gauge(tp, value); err != nil {
+			ReportError(h, "Gauge", err)
+		}
 	}
 }
 
 func (tp *tracepoint) Duration(d time.Duration) {
 	if h, ok := tp.Handler(); ok {
-		h.Duration(tp, d)
+		if err := h.Duration(tp, d); err != nil {
+			ReportError(h, "Duration", err)
+		}
 	}
 }
 
 func (tp *tracepoint) Histogram(sample int64) {
 	if h, ok := tp.Handler(); ok {
-		_ = h
-		// TODO: https://github.com/openhistogram/libcircllhist
+		if err := h.Histogram(tp, sample); err != nil {
+			ReportError(h, "Histogram", err)
+		}
 	}
 }
 
-func (tp *tracepoint) log(tr Trace, skip int, level Level, attrs []Attr) {
-	if h, ok := tp.Handler(); ok {
-		if h.Enabled(level) {
-			flags := h.Flags()
-
-			if (flags & FlagGoroutineID) == FlagGoroutineID {
-				if attrs == nil {
-					attrs = make([]Attr, 0)
-				}
-				gid := __caution__GetGoroutineID()
-				attrs = append(attrs, Uint64("gid", gid))
-			}
+func (tp *tracepoint) log(ctx context.Context, tr Trace, skip int, level Level, msg string, attrs []Attr) {
+	subs := subscribers.load()
+	fr := flightRecorder.Load()
 
-			if (flags & FlagSourceInfo) == FlagSourceInfo {
-				if attrs == nil {
-					attrs = make([]Attr, 0)
-				}
-				_, file, line, _ := runtime.Caller(skip)
-				attrs = append(attrs, String("file", file), Int("line", line))
-			}
+	h, ch, g := tp.snapshot()
+	if g != nil {
+		defer g.mu.RUnlock()
+	}
+	if h == nil || !tp.enabled(ctx, ch, level) {
+		ch = nil
+	}
+	if ch == nil && !wants(subs, level) && fr == nil {
+		return
+	}
+
+	var flags HandlerFlags
+	if ch != nil {
+		flags = h.Flags()
+	}
+
+	r := NewRecord(time.Now(), level, msg, 0)
+	r.Site = tp
+	r.Trace = tr
+	r.attrs = attrs
+
+	if (flags & FlagPreformatAttrs) != FlagPreformatAttrs {
+		r.front = tr.Attrs()
+	}
+
+	if (flags & FlagGoroutineID) == FlagGoroutineID {
+		gid := __caution__GetGoroutineID()
+		r.AddAttrs(Uint64("gid", gid))
+	}
 
-			h.Log(tr, level, attrs)
+	if (flags&FlagStackTrace) == FlagStackTrace && level <= StackTraceLevel() && !hasStackTrace(attrs) {
+		r.AddAttrs(StackTrace(CaptureStack(skip)))
+	}
+
+	if (flags&FlagSourceInfo) == FlagSourceInfo || len(subs) > 0 || (fr != nil && fr.opts.SourceInfo) {
+		var pcs [1]uintptr
+		runtime.Callers(skip+1, pcs[:])
+		r.PC = pcs[0]
+	}
+
+	// Subscribers and the FlightRecorder keep the Record after the event is
+	// logged, so it must neither share the caller's attrs nor keep the trace
+	// alive.
+	if len(subs) > 0 || fr != nil {
+		sr := r
+		sr.Trace = detach(tr)
+		sr.front = tr.Attrs()
+		sr.attrs = append([]Attr(nil), r.attrs...)
+		if len(subs) > 0 {
+			publish(subs, sr)
+		}
+		if fr != nil {
+			fr.record(sr)
+		}
+	}
+
+	if ch != nil {
+		if (flags & FlagSourceInfo) != FlagSourceInfo {
+			r.PC = 0
+		}
+		if err := ch.HandleContext(ctx, r); err != nil {
+			ReportError(h, "Log", err)
 		}
 	}
 }
 
-func (tp *tracepoint) finishTrace(tr Trace, attrs []Attr) {
+func (tp *tracepoint) linkTrace(tr Trace, l Link) {
 	if h, ok := tp.Handler(); ok {
-		h.TraceFinished(tr, attrs)
+		if lh, ok := h.(LinkHandler); ok {
+			lh.TraceLinked(tr, l)
+		}
+	}
+}
+
+func (tp *tracepoint) finishTrace(ctx context.Context, tr Trace, attrs []Attr) {
+	if _, ch, ok := tp.contextHandler(); ok {
+		ch.TraceFinishedContext(ctx, tr, attrs)
+	}
+}
+
+// hasStackTrace reports whether attrs include a stack trace, like the events
+// logged by Recover.
+func hasStackTrace(attrs []Attr) bool {
+	for _, a := range attrs {
+		if a.Kind() == StackKind {
+			return true
+		}
+	}
+	return false
+}
+
+// withoutLinks returns a copy of attrs without the attrs of LinkKind.
+func withoutLinks(attrs []Attr) []Attr {
+	arr := make([]Attr, 0, len(attrs))
+	for _, a := range attrs {
+		if a.Kind() != LinkKind {
+			arr = append(arr, a)
+		}
 	}
+	return arr
 }
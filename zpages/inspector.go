@@ -0,0 +1,462 @@
+/*
+Package zpages serves an HTML page of the traces in flight at each site and
+of the traces that recently finished, like the /debug/requests page of
+golang.org/x/net/trace.
+
+	in := zpages.New(h, trace.DebugLevel, reg)
+	for _, tp := range trace.Sites() {
+		tp.Install(in)
+	}
+	http.Handle("/debug/traces", in)
+*/
+package zpages
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/dzrw/trace"
+)
+
+var _ = trace.Handler(&Inspector{})
+var _ = trace.RecordHandler(&Inspector{})
+var _ = trace.ContextHandler(&Inspector{})
+var _ = trace.LinkHandler(&Inspector{})
+var _ = trace.Flusher(&Inspector{})
+var _ = trace.Closer(&Inspector{})
+var _ = trace.HandlerGroup(&Inspector{})
+
+// Limits on the memory held by an Inspector.
+const (
+	MaxActive   = 1000 // MaxActive is the number of live traces tracked per site.
+	MaxEvents   = 16   // MaxEvents is the number of recent events kept per trace.
+	MaxFinished = 10   // MaxFinished is the number of traces kept per bucket.
+)
+
+// Bands are the upper bounds of the latency bands by which finished traces
+// are bucketed. Traces that take longer than the last bound go in a final
+// band.
+var Bands = []time.Duration{
+	10 * time.Millisecond,
+	100 * time.Millisecond,
+	time.Second,
+	10 * time.Second,
+}
+
+/*
+Inspector is a Handler that tracks the live traces of each site, along with
+their recent events, and keeps the most recent traces to finish in buckets by
+latency band and by failure. Everything is passed through to a downstream
+Handler unchanged.
+
+At most MaxActive live traces are tracked per site; traces created while a
+site is full are not tracked, but are counted.
+*/
+type Inspector struct {
+	next  trace.Handler
+	ch    trace.ContextHandler // next, adapted once
+	rh    trace.RecordHandler  // next, adapted once
+	level trace.Level
+	reg   trace.Registry
+
+	mu    sync.RWMutex
+	sites map[trace.Tracepoint]*site
+}
+
+// TraceInfo describes a live or finished trace.
+type TraceInfo struct {
+	Site    string
+	ID      uint64
+	Start   time.Time
+	Elapsed time.Duration
+	Attrs   []trace.Attr
+	Status  trace.Status // Status is the outcome of a finished trace.
+	Events  []EventInfo  // Events are the most recent events of the trace.
+}
+
+// EventInfo describes an event of a trace.
+type EventInfo struct {
+	Time    time.Time
+	Level   trace.Level
+	Message string
+	Attrs   []trace.Attr
+}
+
+// SiteSummary counts the traces of a site.
+type SiteSummary struct {
+	Site     string
+	Active   int   // Active is the number of live traces tracked.
+	Overflow int64 // Overflow is the number of live traces that were not tracked.
+	Bands    []int64
+	Errors   int64
+}
+
+// site holds the traces of a Tracepoint.
+type site struct {
+	mu       sync.Mutex
+	live     map[uint64]*liveTrace
+	overflow int64
+	bands    []ring
+	errors   ring
+}
+
+type liveTrace struct {
+	info TraceInfo
+	next int // where the next event goes once Events is full
+}
+
+// ring holds the most recent MaxFinished traces added to it, and counts all
+// of them.
+type ring struct {
+	arr   []TraceInfo
+	next  int
+	total int64
+}
+
+func (r *ring) add(info TraceInfo) {
+	r.total++
+	if len(r.arr) < MaxFinished {
+		r.arr = append(r.arr, info)
+		return
+	}
+	r.arr[r.next] = info
+	r.next = (r.next + 1) % MaxFinished
+}
+
+// recent returns the traces in the ring, most recent first.
+func (r *ring) recent() []TraceInfo {
+	arr := make([]TraceInfo, 0, len(r.arr))
+	for i := len(r.arr) - 1; i >= 0; i-- {
+		arr = append(arr, r.arr[(r.next+i)%len(r.arr)])
+	}
+	return arr
+}
+
+// New creates an Inspector that passes everything through to next, which may
+// be nil. Events at level or more severe are kept with their traces. Sites
+// are identified by reg.
+func New(next trace.Handler, level trace.Level, reg trace.Registry) *Inspector {
+	if next == nil {
+		next = nopHandler{}
+	}
+	if reg == nil {
+		reg = trace.NewRegistry()
+	}
+	return &Inspector{
+		next:  next,
+		ch:    trace.AdaptContextHandler(next),
+		rh:    trace.AdaptHandler(next),
+		level: level,
+		reg:   reg,
+		sites: make(map[trace.Tracepoint]*site),
+	}
+}
+
+func (h *Inspector) site(tp trace.Tracepoint) *site {
+	h.mu.RLock()
+	s, ok := h.sites[tp]
+	h.mu.RUnlock()
+	if ok {
+		return s
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if s, ok = h.sites[tp]; !ok {
+		s = &site{
+			live:  make(map[uint64]*liveTrace),
+			bands: make([]ring, len(Bands)+1),
+		}
+		h.sites[tp] = s
+	}
+	return s
+}
+
+func (h *Inspector) Flags() trace.HandlerFlags {
+	return h.next.Flags()
+}
+
+// Handlers returns the downstream Handler.
+func (h *Inspector) Handlers() []trace.Handler {
+	return []trace.Handler{h.next}
+}
+
+func (h *Inspector) Enabled(l trace.Level) bool {
+	return l <= h.level || h.next.Enabled(l)
+}
+
+func (h *Inspector) EnabledContext(ctx context.Context, l trace.Level) bool {
+	return l <= h.level || h.ch.EnabledContext(ctx, l)
+}
+
+func (h *Inspector) TraceCreated(tr trace.Trace, attrs []trace.Attr) {
+	h.created(tr)
+	h.next.TraceCreated(tr, attrs)
+}
+
+func (h *Inspector) TraceCreatedContext(ctx context.Context, tr trace.Trace, attrs []trace.Attr) {
+	h.created(tr)
+	h.ch.TraceCreatedContext(ctx, tr, attrs)
+}
+
+func (h *Inspector) TraceFinished(tr trace.Trace, attrs []trace.Attr) {
+	h.finished(tr)
+	h.next.TraceFinished(tr, attrs)
+}
+
+func (h *Inspector) TraceFinishedContext(ctx context.Context, tr trace.Trace, attrs []trace.Attr) {
+	h.finished(tr)
+	h.ch.TraceFinishedContext(ctx, tr, attrs)
+}
+
+func (h *Inspector) TraceLinked(tr trace.Trace, l trace.Link) {
+	if lh, ok := h.next.(trace.LinkHandler); ok {
+		lh.TraceLinked(tr, l)
+	}
+}
+
+func (h *Inspector) Count(tp trace.Tracepoint, delta int64) error {
+	return h.next.Count(tp, delta)
+}
+
+func (h *Inspector) Gauge(tp trace.Tracepoint, value int64) error {
+	return h.next.Gauge(tp, value)
+}
+
+func (h *Inspector) Duration(tp trace.Tracepoint, d time.Duration) error {
+	return h.next.Duration(tp, d)
+}
+
+func (h *Inspector) Histogram(tp trace.Tracepoint, sample int64) error {
+	return h.next.Histogram(tp, sample)
+}
+
+func (h *Inspector) Log(tr trace.Trace, l trace.Level, attrs ...[]trace.Attr) error {
+	r := trace.NewRecord(time.Now(), l, "", 0)
+	r.Site, r.Trace = tr.Site(), tr
+	for _, arr := range attrs {
+		r.AddAttrs(arr...)
+	}
+	return h.Handle(r)
+}
+
+func (h *Inspector) Handle(r trace.Record) error {
+	h.event(r)
+	if h.next.Enabled(r.Level) {
+		return h.rh.Handle(r)
+	}
+	return nil
+}
+
+func (h *Inspector) HandleContext(ctx context.Context, r trace.Record) error {
+	h.event(r)
+	if h.ch.EnabledContext(ctx, r.Level) {
+		return h.ch.HandleContext(ctx, r)
+	}
+	return nil
+}
+
+// Flush flushes the downstream Handler if it is a Flusher.
+func (h *Inspector) Flush() error {
+	if f, ok := h.next.(trace.Flusher); ok {
+		return f.Flush()
+	}
+	return nil
+}
+
+// Close closes the downstream Handler if it is a Closer.
+func (h *Inspector) Close() error {
+	if c, ok := h.next.(trace.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+func (h *Inspector) created(tr trace.Trace) {
+	id, _ := h.reg.IdentifierFor(tr.Site())
+	s := h.site(tr.Site())
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.live) >= MaxActive {
+		s.overflow++
+		return
+	}
+	s.live[tr.ID()] = &liveTrace{info: TraceInfo{
+		Site:  id,
+		ID:    tr.ID(),
+		Start: time.Now().Add(-tr.Elapsed()),
+		Attrs: tr.Attrs(),
+	}}
+}
+
+func (h *Inspector) finished(tr trace.Trace) {
+	s := h.site(tr.Site())
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	lt, ok := s.live[tr.ID()]
+	if !ok {
+		id, _ := h.reg.IdentifierFor(tr.Site())
+		lt = &liveTrace{info: TraceInfo{
+			Site:  id,
+			ID:    tr.ID(),
+			Start: time.Now().Add(-tr.Elapsed()),
+			Attrs: tr.Attrs(),
+		}}
+	}
+	delete(s.live, tr.ID())
+
+	info := lt.info
+	info.Elapsed = tr.Elapsed()
+	info.Status = tr.Status()
+	info.Events = lt.events()
+
+	s.bands[band(info.Elapsed)].add(info)
+	if info.Status.Failed() {
+		s.errors.add(info)
+	}
+}
+
+func (h *Inspector) event(r trace.Record) {
+	if r.Level > h.level || r.Trace == nil {
+		return
+	}
+
+	// The attrs of the trace are shown once, with the trace.
+	skip := 0
+	if (h.next.Flags() & trace.FlagPreformatAttrs) != trace.FlagPreformatAttrs {
+		skip = len(r.Trace.Attrs())
+	}
+	ev := EventInfo{Time: r.Time, Level: r.Level, Message: r.Message}
+	i := 0
+	r.Attrs(func(a trace.Attr) bool {
+		if i >= skip {
+			ev.Attrs = append(ev.Attrs, a)
+		}
+		i++
+		return true
+	})
+
+	s := h.site(r.Site)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if lt, ok := s.live[r.Trace.ID()]; ok {
+		if len(lt.info.Events) < MaxEvents {
+			lt.info.Events = append(lt.info.Events, ev)
+		} else {
+			lt.info.Events[lt.next] = ev
+			lt.next = (lt.next + 1) % MaxEvents
+		}
+	}
+}
+
+// events returns the events of the trace in order.
+func (lt *liveTrace) events() []EventInfo {
+	arr := make([]EventInfo, 0, len(lt.info.Events))
+	arr = append(arr, lt.info.Events[lt.next:]...)
+	return append(arr, lt.info.Events[:lt.next]...)
+}
+
+func band(d time.Duration) int {
+	for i, b := range Bands {
+		if d < b {
+			return i
+		}
+	}
+	return len(Bands)
+}
+
+// Summary counts the traces of each site, sorted by identifier.
+func (h *Inspector) Summary() []SiteSummary {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	arr := make([]SiteSummary, 0, len(h.sites))
+	for tp, s := range h.sites {
+		id, _ := h.reg.IdentifierFor(tp)
+		s.mu.Lock()
+		sum := SiteSummary{
+			Site:     id,
+			Active:   len(s.live),
+			Overflow: s.overflow,
+			Bands:    make([]int64, len(s.bands)),
+			Errors:   s.errors.total,
+		}
+		for i := range s.bands {
+			sum.Bands[i] = s.bands[i].total
+		}
+		s.mu.Unlock()
+		arr = append(arr, sum)
+	}
+	sortSummaries(arr)
+	return arr
+}
+
+// Active returns the live traces of the site identified by id, oldest first.
+func (h *Inspector) Active(id string) []TraceInfo {
+	var arr []TraceInfo
+	h.each(id, func(s *site) {
+		now := time.Now()
+		for _, lt := range s.live {
+			info := lt.info
+			info.Elapsed = now.Sub(info.Start)
+			info.Events = lt.events()
+			arr = append(arr, info)
+		}
+	})
+	sortTraces(arr)
+	return arr
+}
+
+// Finished returns the most recent traces of the site identified by id that
+// finished in the latency band b, most recent first.
+func (h *Inspector) Finished(id string, b int) []TraceInfo {
+	var arr []TraceInfo
+	h.each(id, func(s *site) {
+		if b >= 0 && b < len(s.bands) {
+			arr = s.bands[b].recent()
+		}
+	})
+	return arr
+}
+
+// Failed returns the most recent traces of the site identified by id that
+// failed, most recent first.
+func (h *Inspector) Failed(id string) []TraceInfo {
+	var arr []TraceInfo
+	h.each(id, func(s *site) {
+		arr = s.errors.recent()
+	})
+	return arr
+}
+
+// each calls f on the site identified by id, locked.
+func (h *Inspector) each(id string, f func(*site)) {
+	tp, ok := h.reg.TracepointFor(id)
+	if !ok {
+		return
+	}
+	h.mu.RLock()
+	s, ok := h.sites[tp]
+	h.mu.RUnlock()
+	if ok {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		f(s)
+	}
+}
+
+// nopHandler stands in for a missing downstream Handler.
+type nopHandler struct{}
+
+func (nopHandler) Flags() trace.HandlerFlags                           { return 0 }
+func (nopHandler) Enabled(trace.Level) bool                            { return false }
+func (nopHandler) Count(trace.Tracepoint, int64) error                 { return nil }
+func (nopHandler) Gauge(trace.Tracepoint, int64) error                 { return nil }
+func (nopHandler) Duration(trace.Tracepoint, time.Duration) error      { return nil }
+func (nopHandler) Histogram(trace.Tracepoint, int64) error             { return nil }
+func (nopHandler) Log(trace.Trace, trace.Level, ...[]trace.Attr) error { return nil }
+func (nopHandler) TraceCreated(trace.Trace, []trace.Attr)              {}
+func (nopHandler) TraceFinished(trace.Trace, []trace.Attr)             {}
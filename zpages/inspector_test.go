@@ -0,0 +1,113 @@
+package zpages_test
+
+import (
+	"errors"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/dzrw/trace"
+	"github.com/dzrw/trace/zpages"
+	"github.com/stretchr/testify/require"
+)
+
+var SiteTestQuery = trace.Site()
+
+func TestInspector(t *testing.T) {
+	reg := trace.NewRegistry()
+	reg.Define(SiteTestQuery, "db.query")
+
+	in := zpages.New(nil, trace.DebugLevel, reg)
+	SiteTestQuery.Install(in)
+	defer SiteTestQuery.Uninstall()
+
+	live := SiteTestQuery.Trace(trace.String("table", "users"))
+	for i := 0; i < zpages.MaxEvents+2; i++ {
+		live.Debug("step", trace.Int("i", i))
+	}
+
+	done := SiteTestQuery.Trace()
+	done.Info("querying")
+	done.Close()
+
+	failed := SiteTestQuery.Trace()
+	failed.CloseWithError(errors.New("timeout"))
+
+	active := in.Active("db.query")
+	require.Len(t, active, 1)
+	require.Equal(t, live.ID(), active[0].ID)
+	require.Equal(t, []trace.Attr{trace.String("table", "users")}, active[0].Attrs)
+	require.Len(t, active[0].Events, zpages.MaxEvents)
+	require.Equal(t, []trace.Attr{trace.Int("i", 2)}, active[0].Events[0].Attrs)
+
+	finished := in.Finished("db.query", 0)
+	require.Len(t, finished, 2)
+	require.Equal(t, failed.ID(), finished[0].ID)
+	require.Equal(t, done.ID(), finished[1].ID)
+	require.Equal(t, "querying", finished[1].Events[0].Message)
+
+	errs := in.Failed("db.query")
+	require.Len(t, errs, 1)
+	require.Equal(t, trace.StatusError, errs[0].Status.Code)
+
+	sum := in.Summary()
+	require.Len(t, sum, 1)
+	require.Equal(t, 1, sum[0].Active)
+	require.Equal(t, int64(2), sum[0].Bands[0])
+	require.Equal(t, int64(1), sum[0].Errors)
+
+	rec := httptest.NewRecorder()
+	in.ServeHTTP(rec, httptest.NewRequest("GET", "/debug/traces?site=db.query&b=errors", nil))
+	require.Equal(t, 200, rec.Code)
+	require.Contains(t, rec.Body.String(), "db.query: errors")
+	require.Contains(t, rec.Body.String(), "timeout")
+
+	rec = httptest.NewRecorder()
+	in.ServeHTTP(rec, httptest.NewRequest("GET", "/debug/traces?site=db.query&b=9", nil))
+	require.Equal(t, 400, rec.Code)
+
+	live.Close()
+	require.Empty(t, in.Active("db.query"))
+}
+
+func TestInspectorBounds(t *testing.T) {
+	reg := trace.NewRegistry()
+	reg.Define(SiteTestQuery, "db.query")
+
+	in := zpages.New(nil, trace.DebugLevel, reg)
+	SiteTestQuery.Install(in)
+	defer SiteTestQuery.Uninstall()
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var open []trace.Trace
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < zpages.MaxActive/4+25; j++ {
+				tr := SiteTestQuery.Trace()
+				tr.Debug("open")
+				mu.Lock()
+				open = append(open, tr)
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	sum := in.Summary()[0]
+	require.Equal(t, zpages.MaxActive, sum.Active)
+	require.Equal(t, int64(100), sum.Overflow)
+
+	for _, tr := range open {
+		tr.Close()
+	}
+	require.Empty(t, in.Active("db.query"))
+	var total int64
+	for _, n := range in.Summary()[0].Bands {
+		total += n
+	}
+	require.Equal(t, int64(zpages.MaxActive+100), total)
+	require.Len(t, in.Finished("db.query", 0), zpages.MaxFinished)
+}
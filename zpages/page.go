@@ -0,0 +1,131 @@
+package zpages
+
+import (
+	"html/template"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/dzrw/trace"
+)
+
+/*
+ServeHTTP serves the page. Without a query, it lists the sites with the
+number of live traces and of finished traces in each bucket. The query
+selects the traces of a bucket:
+
+	?site=db.query&b=active  the live traces of db.query
+	?site=db.query&b=2       the recent traces of db.query in band 2
+	?site=db.query&b=errors  the recent traces of db.query that failed
+*/
+func (h *Inspector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	data := pageData{Summary: h.Summary()}
+	for i, b := range Bands {
+		data.Bands = append(data.Bands, "<"+b.String())
+		if i == len(Bands)-1 {
+			data.Bands = append(data.Bands, "≥"+b.String())
+		}
+	}
+
+	q := r.URL.Query()
+	if id := q.Get("site"); id != "" {
+		data.Site = id
+		switch b := q.Get("b"); b {
+		case "active":
+			data.Bucket = "active"
+			data.Traces = h.Active(id)
+		case "errors":
+			data.Bucket = "errors"
+			data.Traces = h.Failed(id)
+		default:
+			n, err := strconv.Atoi(b)
+			if err != nil || n < 0 || n > len(Bands) {
+				http.Error(w, "invalid bucket "+strconv.Quote(b), http.StatusBadRequest)
+				return
+			}
+			data.Bucket = data.Bands[n]
+			data.Traces = h.Finished(id, n)
+		}
+		data.Selected = true
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := page.Execute(w, data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+type pageData struct {
+	Summary  []SiteSummary
+	Bands    []string
+	Selected bool
+	Site     string
+	Bucket   string
+	Traces   []TraceInfo
+}
+
+var page = template.Must(template.New("zpages").Funcs(template.FuncMap{
+	"attrs": formatAttrs,
+	"since": func(start, t time.Time) string { return t.Sub(start).String() },
+	"time":  func(t time.Time) string { return t.Format(trace.RFC3339Milli) },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<title>traces</title>
+<style>
+body { font-family: sans-serif; }
+table { border-collapse: collapse; }
+th, td { padding: 2px 8px; text-align: left; vertical-align: top; }
+tr:nth-child(even) { background: #f4f4f4; }
+.failed { color: #b00; }
+.event { font-family: monospace; white-space: pre; }
+</style>
+</head>
+<body>
+<h1>Traces</h1>
+<table>
+<tr><th>Site</th><th>Active</th>{{range .Bands}}<th>{{.}}</th>{{end}}<th>Errors</th></tr>
+{{range $s := .Summary}}<tr>
+<td>{{$s.Site}}</td>
+<td><a href="?site={{$s.Site}}&amp;b=active">{{$s.Active}}</a>{{if $s.Overflow}} (+{{$s.Overflow}} untracked){{end}}</td>
+{{range $i, $n := $s.Bands}}<td><a href="?site={{$s.Site}}&amp;b={{$i}}">{{$n}}</a></td>{{end}}
+<td><a href="?site={{$s.Site}}&amp;b=errors">{{$s.Errors}}</a></td>
+</tr>{{end}}
+</table>
+{{if .Selected}}
+<h2>{{.Site}}: {{.Bucket}}</h2>
+<table>
+<tr><th>Start</th><th>Elapsed</th><th>Trace</th><th>Attrs</th><th>Status</th></tr>
+{{range $t := .Traces}}<tr{{if $t.Status.Failed}} class="failed"{{end}}>
+<td>{{time $t.Start}}</td><td>{{$t.Elapsed}}</td><td>{{$t.ID}}</td><td>{{attrs $t.Attrs}}</td><td>{{attrs $t.Status.Attrs}}</td>
+</tr>
+{{range $t.Events}}<tr><td></td><td class="event">+{{since $t.Start .Time}}</td><td class="event">{{.Level}}</td><td class="event" colspan="2">{{.Message}} {{attrs .Attrs}}</td></tr>
+{{end}}{{end}}
+</table>
+{{end}}
+</body>
+</html>
+`))
+
+func formatAttrs(attrs []trace.Attr) string {
+	b := []byte{}
+	for i, a := range attrs {
+		if i > 0 {
+			b = append(b, ' ')
+		}
+		k, v := a.Format()
+		b = append(b, k...)
+		b = append(b, '=')
+		b = append(b, v...)
+	}
+	return string(b)
+}
+
+func sortSummaries(arr []SiteSummary) {
+	sort.Slice(arr, func(i, j int) bool { return arr[i].Site < arr[j].Site })
+}
+
+func sortTraces(arr []TraceInfo) {
+	sort.Slice(arr, func(i, j int) bool { return arr[i].Start.Before(arr[j].Start) })
+}